@@ -9,26 +9,12 @@ type paramsKey struct{}
 
 var ParamsTag = paramsKey{}
 
-type AdapterResponseWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *AdapterResponseWriter) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
-}
-
-func (w *AdapterResponseWriter) Status() int {
-	return w.status
-}
-
 // Adapt adapts a standard http.Handler to be used as a Heligo handler.
 // In the standard handler one can retrieve parameters from the context,
 // using ParamsFromContext.
 func Adapt(h http.Handler) Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
-		rw := &AdapterResponseWriter{w, http.StatusOK}
+		rw := NewResponseWriter(w)
 		req := r.Request
 		if r.params.count > 0 {
 			ctx := req.Context()
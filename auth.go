@@ -0,0 +1,224 @@
+package heligo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned by the authentication middlewares
+// when the request carries no valid credentials.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Principal is the identity of an authenticated client.
+type Principal struct {
+	Subject string
+	Scheme  string
+	Claims  map[string]any
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext gets the authenticated principal from the context
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// unauthorized sets the challenge header and returns the 401 status and error
+// to be rendered by the router ErrorHandler.
+func unauthorized(w http.ResponseWriter, challenge string, err error) (int, error) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	if err == nil {
+		err = ErrUnauthorized
+	} else if !errors.Is(err, ErrUnauthorized) {
+		err = fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return http.StatusUnauthorized, err
+}
+
+// secureCompare compares two strings in constant time,
+// without leaking their lengths.
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// BasicAuth returns a middleware that authenticates requests with the
+// HTTP Basic scheme against the given user/password pairs.
+// Failures are returned as 401 with a Basic challenge for the realm.
+func BasicAuth(realm string, accounts map[string]string) Middleware {
+	challenge := `Basic realm=` + strconv.Quote(realm) + `, charset="UTF-8"`
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			user, password, ok := r.BasicAuth()
+			if !ok {
+				return unauthorized(w, challenge, nil)
+			}
+			// always compare against something to avoid leaking valid users
+			expected, found := accounts[user]
+			if !secureCompare(password, expected) || !found {
+				return unauthorized(w, challenge, nil)
+			}
+			ctx = WithPrincipal(ctx, Principal{Subject: user, Scheme: "Basic"})
+			return next(ctx, w, r)
+		}
+	}
+}
+
+// BearerAuth returns a middleware that authenticates requests carrying
+// an "Authorization: Bearer <token>" header.
+// The validate function checks the token and returns the principal; its
+// errors are reported with an RFC 6750 invalid_token challenge.
+func BearerAuth(validate func(ctx context.Context, token string) (Principal, error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			token, ok := bearerToken(r.Request)
+			if !ok {
+				return unauthorized(w, "Bearer", nil)
+			}
+			p, err := validate(ctx, token)
+			if err != nil {
+				return unauthorized(w, `Bearer error="invalid_token"`, err)
+			}
+			if p.Scheme == "" {
+				p.Scheme = "Bearer"
+			}
+			ctx = WithPrincipal(ctx, p)
+			return next(ctx, w, r)
+		}
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// HMACScheme is the authorization scheme used by signed requests.
+const HMACScheme = "HMAC-SHA256"
+
+// maxSignedBody limits the body read to verify a signature.
+const maxSignedBody = 1 << 20
+
+// SignRequest signs r with the given key, setting an Authorization header
+// of the form:
+//
+//	HMAC-SHA256 keyId="<id>", ts="<unix seconds>", sig="<base64 signature>"
+//
+// The signature covers the method, the request URI, the timestamp and the
+// SHA-256 of the body, which is read and restored.
+func SignRequest(r *http.Request, keyID string, key []byte) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hmacSignature(key, r.Method, r.URL.RequestURI(), ts, body)
+	r.Header.Set("Authorization", fmt.Sprintf(`%s keyId=%q, ts=%q, sig=%q`, HMACScheme, keyID, ts, sig))
+	return nil
+}
+
+// HMACAuth returns a middleware that verifies requests signed with SignRequest.
+// The keys function returns the secret for a key id; the key id becomes the
+// principal subject. Signatures whose timestamp differs from the current time
+// by more than maxSkew are rejected.
+func HMACAuth(keys func(ctx context.Context, keyID string) ([]byte, error), maxSkew time.Duration) Middleware {
+	challenge := HMACScheme
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, HMACScheme+" ") {
+				return unauthorized(w, challenge, nil)
+			}
+			fields := parseAuthParams(auth[len(HMACScheme)+1:])
+			keyID, ts, sig := fields["keyId"], fields["ts"], fields["sig"]
+			if keyID == "" || ts == "" || sig == "" {
+				return unauthorized(w, challenge, errors.New("malformed signature"))
+			}
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return unauthorized(w, challenge, errors.New("malformed timestamp"))
+			}
+			if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+				return unauthorized(w, challenge, errors.New("signature expired"))
+			}
+			key, err := keys(ctx, keyID)
+			if err != nil {
+				return unauthorized(w, challenge, err)
+			}
+			body, err := readBody(r.Request)
+			if err != nil {
+				return http.StatusRequestEntityTooLarge, err
+			}
+			expected := hmacSignature(key, r.Method, r.URL.RequestURI(), ts, body)
+			if !hmac.Equal([]byte(sig), []byte(expected)) {
+				return unauthorized(w, challenge, errors.New("invalid signature"))
+			}
+			ctx = WithPrincipal(ctx, Principal{Subject: keyID, Scheme: HMACScheme})
+			return next(ctx, w, r)
+		}
+	}
+}
+
+func hmacSignature(key []byte, method, uri, ts string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, method+"\n"+uri+"\n"+ts+"\n"+hex.EncodeToString(sum[:]))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readBody reads the whole body, up to maxSignedBody, and restores it
+// so that the next handlers can read it again.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBody {
+		return nil, errors.New("body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// parseAuthParams parses a comma separated list of key="value" pairs.
+func parseAuthParams(s string) map[string]string {
+	m := make(map[string]string)
+	for _, field := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		if uv, err := strconv.Unquote(v); err == nil {
+			v = uv
+		}
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,150 @@
+package heligo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func newAuthRouter(m heligo.Middleware) *heligo.Router {
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		http.Error(w, err.Error(), status)
+	}
+	router.Use(m)
+	router.Handle("GET", "/me", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		p, ok := heligo.PrincipalFromContext(ctx)
+		if !ok {
+			return http.StatusInternalServerError, errors.New("no principal")
+		}
+		w.Write([]byte(p.Scheme + ":" + p.Subject))
+		return 200, nil
+	})
+	router.Handle("POST", "/me", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		return 200, nil
+	})
+	return router
+}
+
+func TestBasicAuth(t *testing.T) {
+	router := newAuthRouter(heligo.BasicAuth("test", map[string]string{"alice": "secret"}))
+
+	tests := []struct {
+		user, password string
+		status         int
+	}{
+		{"alice", "secret", 200},
+		{"alice", "wrong", 401},
+		{"bob", "secret", 401},
+		{"", "", 401},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/me", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s/%s: expected %d, got %d", test.user, test.password, test.status, w.Code)
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") != `Basic realm="test", charset="UTF-8"` {
+			t.Errorf("wrong challenge %q", w.Header().Get("WWW-Authenticate"))
+		}
+		if w.Code == 200 && w.Body.String() != "Basic:alice" {
+			t.Errorf("wrong principal %q", w.Body.String())
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	router := newAuthRouter(heligo.BearerAuth(func(ctx context.Context, token string) (heligo.Principal, error) {
+		if token != "t0ken" {
+			return heligo.Principal{}, errors.New("unknown token")
+		}
+		return heligo.Principal{Subject: "svc"}, nil
+	}))
+
+	tests := []struct {
+		auth      string
+		status    int
+		challenge string
+	}{
+		{"Bearer t0ken", 200, ""},
+		{"bearer t0ken", 200, ""},
+		{"Bearer other", 401, `Bearer error="invalid_token"`},
+		{"Basic abc", 401, "Bearer"},
+		{"", 401, "Bearer"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/me", nil)
+		r.Header.Set("Authorization", test.auth)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%q: expected %d, got %d", test.auth, test.status, w.Code)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != test.challenge {
+			t.Errorf("%q: expected challenge %q, got %q", test.auth, test.challenge, got)
+		}
+		if w.Code == 200 && w.Body.String() != "Bearer:svc" {
+			t.Errorf("wrong principal %q", w.Body.String())
+		}
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	key := []byte("k3y")
+	router := newAuthRouter(heligo.HMACAuth(func(ctx context.Context, keyID string) ([]byte, error) {
+		if keyID != "client1" {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	}, time.Minute))
+
+	// valid signature, body is still readable by the handler
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/me?x=1", bytes.NewBufferString("payload"))
+	heligo.SignRequest(r, "client1", key)
+	router.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != "payload" {
+		t.Errorf("expected 200 payload, got %d %q", w.Code, w.Body.String())
+	}
+
+	// tampered body
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/me", bytes.NewBufferString("payload"))
+	heligo.SignRequest(r, "client1", key)
+	r.Body = io.NopCloser(bytes.NewBufferString("tampered"))
+	router.ServeHTTP(w, r)
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") != heligo.HMACScheme {
+		t.Errorf("tampered body: expected 401, got %d", w.Code)
+	}
+
+	// unknown key
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/me", nil)
+	heligo.SignRequest(r, "client2", key)
+	router.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Errorf("unknown key: expected 401, got %d", w.Code)
+	}
+
+	// principal
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/me", nil)
+	heligo.SignRequest(r, "client1", key)
+	router.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != heligo.HMACScheme+":client1" {
+		t.Errorf("expected principal, got %d %q", w.Code, w.Body.String())
+	}
+}
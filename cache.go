@@ -0,0 +1,477 @@
+package heligo
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedResponse is a response stored by the Cache middleware.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored is the time the response was stored, for the Age header
+	Stored time.Time
+	// Expires is the end of the freshness, StaleUntil the end of the
+	// time it can be served while revalidated
+	Expires    time.Time
+	StaleUntil time.Time
+	// Vary are the request headers selecting the response. An entry with
+	// Vary and no Status is the index of the variants of a resource.
+	Vary []string
+}
+
+// CacheStore keeps the cached responses. It must be safe for concurrent
+// use, and can drop the entries at any time.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, bool)
+	Set(ctx context.Context, key string, resp *CachedResponse)
+	Delete(ctx context.Context, key string)
+}
+
+// CacheOptions configures a ResponseCache.
+type CacheOptions struct {
+	// Store keeps the responses, a MemoryCacheStore of 64MB if nil
+	Store CacheStore
+	// TTL is the freshness of the responses without max-age or s-maxage:
+	// if zero they are not cached
+	TTL time.Duration
+	// StaleWhileRevalidate is the time a stale response can be served
+	// while revalidated, for the responses without the directive
+	StaleWhileRevalidate time.Duration
+	// QueryKeys are the query parameters in the cache key, all if nil
+	QueryKeys []string
+	// MaxBodySize is the size of the largest response cached, 1MB if zero
+	MaxBodySize int64
+}
+
+// CacheStats are the counters of a ResponseCache.
+type CacheStats struct {
+	// Hits are the fresh responses served from the cache
+	Hits uint64
+	// StaleHits are the stale responses served while revalidated
+	StaleHits uint64
+	// Misses are the requests served by the handlers
+	Misses uint64
+	// Collapsed are the misses served by a concurrent request for the
+	// same resource
+	Collapsed uint64
+	// Bypasses are the requests not using the cache, with no-store
+	Bypasses uint64
+}
+
+// ResponseCache caches the responses of the Cache middleware.
+type ResponseCache struct {
+	opts      CacheOptions
+	mu        sync.Mutex
+	flights   map[string]*flight
+	hits      atomic.Uint64
+	staleHits atomic.Uint64
+	misses    atomic.Uint64
+	collapsed atomic.Uint64
+	bypasses  atomic.Uint64
+}
+
+// flight is a request for a resource being served by the handler
+type flight struct {
+	done    chan struct{}
+	resp    *CachedResponse // nil if not cacheable
+	variant string          // the variant key of resp, if it varies
+}
+
+// NewResponseCache creates a cache.
+func NewResponseCache(opts CacheOptions) *ResponseCache {
+	if opts.Store == nil {
+		opts.Store = NewMemoryCacheStore(64 << 20)
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	return &ResponseCache{opts: opts, flights: map[string]*flight{}}
+}
+
+// Stats returns the counters of the cache.
+func (c *ResponseCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		StaleHits: c.staleHits.Load(),
+		Misses:    c.misses.Load(),
+		Collapsed: c.collapsed.Load(),
+		Bypasses:  c.bypasses.Load(),
+	}
+}
+
+// Cache returns a middleware caching the responses to GET and HEAD
+// requests in c, as a shared cache.
+//
+// Responses are keyed by method, host, route pattern, parameters, query
+// parameters and the request headers listed in their Vary header. They
+// are fresh for their s-maxage or max-age, or CacheOptions.TTL, and are
+// not stored with no-store, no-cache, private, Set-Cookie, or for requests
+// with Authorization without public or s-maxage. Requests honor no-store,
+// no-cache, max-age and only-if-cached. Stale responses within their
+// stale-while-revalidate time are served while a request revalidates
+// them in the background, and concurrent misses wait for a single request
+// to the handler, unless the last response of the resource was not
+// cacheable. The X-Cache header reports HIT, STALE or MISS.
+//
+// Other methods are not cached, and don't invalidate the cached responses.
+func Cache(c *ResponseCache) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				return next(ctx, w, r)
+			}
+			cc := parseCacheControl(r.Header.Values("Cache-Control"))
+			if len(cc) == 0 && r.Header.Get("Pragma") == "no-cache" {
+				cc["no-cache"] = ""
+			}
+			if cc.has("no-store") {
+				c.bypasses.Add(1)
+				return next(ctx, w, r)
+			}
+			base := c.key(r)
+			if !cc.has("no-cache") {
+				if resp, ok := c.lookup(ctx, base, r); ok {
+					now := time.Now()
+					maxAge, hasMaxAge := cc.seconds("max-age")
+					switch {
+					case now.Before(resp.Expires) && (!hasMaxAge || now.Sub(resp.Stored) <= maxAge):
+						c.hits.Add(1)
+						return c.serve(w, r, resp, "HIT")
+					case now.Before(resp.StaleUntil) && !hasMaxAge:
+						c.staleHits.Add(1)
+						c.revalidate(ctx, next, r, base)
+						return c.serve(w, r, resp, "STALE")
+					}
+				}
+			}
+			if cc.has("only-if-cached") {
+				return WriteHeader(w, http.StatusGatewayTimeout)
+			}
+			return c.fetch(ctx, w, r, next, base)
+		}
+	}
+}
+
+// key returns the cache key of r, without the variant
+func (c *ResponseCache) key(r Request) string {
+	var b strings.Builder
+	rt := r.Route()
+	b.WriteString(r.Method + " " + rt.Host)
+	if rt.Host == "" {
+		// routes without a host serve any of them
+		b.WriteString(strings.ToLower(stripPort(r.Host)))
+	}
+	b.WriteString(rt.Pattern)
+	if rt.Pattern == "" {
+		b.WriteString(r.URL.Path)
+	}
+	for i := 0; i < int(r.params.count); i++ {
+		p := r.ParamByPos(i)
+		b.WriteString("\n" + p.Name + "=" + p.Value)
+	}
+	query := r.URL.Query()
+	if c.opts.QueryKeys != nil {
+		selected := url.Values{}
+		for _, k := range c.opts.QueryKeys {
+			if v, ok := query[k]; ok {
+				selected[k] = v
+			}
+		}
+		query = selected
+	}
+	if len(query) > 0 {
+		b.WriteString("\n?" + query.Encode())
+	}
+	return b.String()
+}
+
+// variantKey returns the key of the variant of base selected by r
+func variantKey(base string, vary []string, r Request) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// lookup gets the response for r, through the index of the variants
+func (c *ResponseCache) lookup(ctx context.Context, base string, r Request) (*CachedResponse, bool) {
+	resp, ok := c.opts.Store.Get(ctx, base)
+	if ok && resp.Status == 0 && len(resp.Vary) > 0 {
+		resp, ok = c.opts.Store.Get(ctx, variantKey(base, resp.Vary, r))
+	}
+	return resp, ok && resp.Status != 0
+}
+
+// serve writes a cached response, or 304 for the conditional requests
+func (c *ResponseCache) serve(w http.ResponseWriter, r Request, resp *CachedResponse, state string) (int, error) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(resp.Stored).Seconds())))
+	h.Set("X-Cache", state)
+	lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+	if r.CheckPreconditions(h.Get("ETag"), lastModified) == http.StatusNotModified {
+		writeNotModified(w)
+		return http.StatusNotModified, nil
+	}
+	w.WriteHeader(resp.Status)
+	_, err := w.Write(resp.Body)
+	return resp.Status, err
+}
+
+// fetch serves r with the handler, storing the response. Concurrent
+// requests for the same resource wait for the first one, unless it was
+// recently not cacheable.
+func (c *ResponseCache) fetch(ctx context.Context, w http.ResponseWriter, r Request, next Handler, base string) (int, error) {
+	c.mu.Lock()
+	if f, ok := c.flights[base]; ok {
+		c.mu.Unlock()
+		if c.passing(ctx, base) {
+			_, status, err := c.miss(ctx, w, r, next, base, true)
+			return status, err
+		}
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return http.StatusServiceUnavailable, ctx.Err()
+		}
+		if f.resp != nil && (len(f.resp.Vary) == 0 || f.variant == variantKey(base, f.resp.Vary, r)) {
+			c.collapsed.Add(1)
+			return c.serve(w, r, f.resp, "MISS")
+		}
+		c.misses.Add(1)
+		return next(ctx, w, r)
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[base] = f
+	c.mu.Unlock()
+	defer c.land(base, f)
+
+	resp, status, err := c.miss(ctx, w, r, next, base, false)
+	if f.resp = resp; resp != nil {
+		f.variant = variantKey(base, resp.Vary, r)
+	}
+	return status, err
+}
+
+// passTTL is the time the misses of a resource whose response was not
+// cacheable are not collapsed, as the hit-for-pass objects of Varnish
+const passTTL = 10 * time.Second
+
+// passKey returns the key of the pass marker of base
+func passKey(base string) string {
+	return base + "\n!pass"
+}
+
+// passing reports whether the last response for base was not cacheable
+func (c *ResponseCache) passing(ctx context.Context, base string) bool {
+	m, ok := c.opts.Store.Get(ctx, passKey(base))
+	return ok && time.Now().Before(m.Expires)
+}
+
+// miss serves r with the handler, storing the response if cacheable.
+// Otherwise it marks base to pass, unless the response depends on the
+// credentials of the request. A cacheable response removes the mark.
+func (c *ResponseCache) miss(ctx context.Context, w http.ResponseWriter, r Request, next Handler, base string, passing bool) (*CachedResponse, int, error) {
+	c.misses.Add(1)
+	w.Header().Set("X-Cache", "MISS")
+	rw := newRecordingWriter(w, c.opts.MaxBodySize)
+	status, err := next(ctx, rw, r)
+	if err != nil {
+		return nil, status, err
+	}
+	resp := c.store(ctx, base, r, rw, status)
+	switch {
+	case resp != nil && passing:
+		c.opts.Store.Delete(ctx, passKey(base))
+	case resp == nil && r.Header.Get("Authorization") == "":
+		now := time.Now()
+		c.opts.Store.Set(ctx, passKey(base), &CachedResponse{Stored: now, Expires: now.Add(passTTL), StaleUntil: now.Add(passTTL)})
+	}
+	return resp, status, nil
+}
+
+// land ends a flight, waking up the waiting requests
+func (c *ResponseCache) land(base string, f *flight) {
+	c.mu.Lock()
+	delete(c.flights, base)
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// revalidate refreshes a stale response in the background,
+// unless a request for it is already in flight
+func (c *ResponseCache) revalidate(ctx context.Context, next Handler, r Request, base string) {
+	c.mu.Lock()
+	if _, ok := c.flights[base]; ok {
+		c.mu.Unlock()
+		return
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[base] = f
+	c.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	bg := r
+	bg.Request = r.Request.Clone(ctx)
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Range"} {
+		bg.Header.Del(h)
+	}
+	go func() {
+		defer c.land(base, f)
+		rw := newRecordingWriter(discardWriter{http.Header{}}, c.opts.MaxBodySize)
+		if status, err := next(ctx, rw, bg); err == nil {
+			f.resp = c.store(ctx, base, bg, rw, status)
+		}
+	}()
+}
+
+// store stores the recorded response if cacheable, returning it
+func (c *ResponseCache) store(ctx context.Context, base string, r Request, rw *recordingWriter, status int) *CachedResponse {
+	header := rw.Header().Clone()
+	if rw.Written() {
+		if !rw.recorded() {
+			return nil
+		}
+		status, header = rw.Status(), rw.header
+	}
+	if !cacheableStatus(status) || header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	header.Del("X-Cache")
+	cc := parseCacheControl(header.Values("Cache-Control"))
+	if cc.has("no-store") || cc.has("no-cache") || cc.has("private") {
+		return nil
+	}
+	if r.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") {
+		return nil
+	}
+	ttl, ok := cc.seconds("s-maxage")
+	if !ok {
+		if ttl, ok = cc.seconds("max-age"); !ok {
+			ttl = c.opts.TTL
+		}
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	swr, ok := cc.seconds("stale-while-revalidate")
+	if !ok {
+		swr = c.opts.StaleWhileRevalidate
+	}
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		swr = 0
+	}
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	now := time.Now()
+	resp := &CachedResponse{
+		Status:     status,
+		Header:     header,
+		Body:       bytes.Clone(rw.body.buf.Bytes()),
+		Stored:     now,
+		Expires:    now.Add(ttl),
+		StaleUntil: now.Add(ttl + swr),
+		Vary:       vary,
+	}
+	key := base
+	if len(vary) > 0 {
+		c.opts.Store.Set(ctx, base, &CachedResponse{Vary: vary, Stored: now, Expires: resp.Expires, StaleUntil: resp.StaleUntil})
+		key = variantKey(base, vary, r)
+	}
+	c.opts.Store.Set(ctx, key, resp)
+	return resp
+}
+
+// cacheableStatus reports whether the status is cacheable by default,
+// as in RFC 9110
+func cacheableStatus(status int) bool {
+	switch status {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// discardWriter is the writer of the background revalidations
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+// MemoryCacheStore is an in-memory CacheStore bounded by the size of the
+// responses, evicting the least recently used ones.
+type MemoryCacheStore struct {
+	mu        sync.Mutex
+	responses *lru[string, *CachedResponse]
+}
+
+// NewMemoryCacheStore creates a store of at most maxSize bytes, as the
+// approximate size of the keys, headers and bodies.
+func NewMemoryCacheStore(maxSize int64) *MemoryCacheStore {
+	return &MemoryCacheStore{responses: newLRU[string, *CachedResponse](maxSize)}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responses.get(key, time.Now())
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, resp *CachedResponse) {
+	size := int64(len(key) + len(resp.Body))
+	for k, v := range resp.Header {
+		size += int64(len(k))
+		for _, s := range v {
+			size += int64(len(s))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses.add(key, resp, size, resp.StaleUntil)
+}
+
+func (s *MemoryCacheStore) Delete(ctx context.Context, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses.remove(key)
+}
+
+// Len returns the number of entries.
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responses.len()
+}
+
+// Size returns the approximate size of the entries.
+func (s *MemoryCacheStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responses.cost
+}
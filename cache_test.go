@@ -0,0 +1,302 @@
+package heligo_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestCache(t *testing.T) {
+	cache := heligo.NewResponseCache(heligo.CacheOptions{TTL: time.Minute, QueryKeys: []string{"page"}})
+	router := heligo.New()
+	router.Use(heligo.Cache(cache))
+	var calls atomic.Int64
+	router.Handle("GET", "/items/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		n := calls.Add(1)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+		if r.URL.Query().Get("private") != "" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		fmt.Fprintf(w, "item %s page %s call %d", r.Param("id"), r.URL.Query().Get("page"), n)
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/lang", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		calls.Add(1)
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Cache-Control", heligo.CacheControl(heligo.Public, heligo.MaxAge(time.Hour)))
+		fmt.Fprint(w, r.Header.Get("Accept-Language"))
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/error", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		calls.Add(1)
+		return heligo.WriteHeader(w, http.StatusInternalServerError)
+	})
+
+	get := func(target string, headers ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(w *httptest.ResponseRecorder, state string, body string) {
+		t.Helper()
+		if w.Code != 200 || w.Header().Get("X-Cache") != state || w.Body.String() != body {
+			t.Errorf("expected %s %q, got %d %s %q", state, body, w.Code, w.Header().Get("X-Cache"), w.Body)
+		}
+	}
+
+	expect(get("/items/1"), "MISS", "item 1 page  call 1")
+	w := get("/items/1")
+	expect(w, "HIT", "item 1 page  call 1")
+	if w.Header().Get("Age") != "0" || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+	// the key has the params and the selected query keys
+	expect(get("/items/2"), "MISS", "item 2 page  call 2")
+	expect(get("/items/1?page=2"), "MISS", "item 1 page 2 call 3")
+	expect(get("/items/1?page=2&utm=x"), "HIT", "item 1 page 2 call 3")
+
+	// conditional requests to cached responses
+	if w := get("/items/1", "If-None-Match", `"1"`); w.Code != 304 || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected 304 from the cache, got %d", w.Code)
+	}
+
+	// request directives
+	expect(get("/items/1", "Cache-Control", "no-cache"), "MISS", "item 1 page  call 4")
+	expect(get("/items/1"), "HIT", "item 1 page  call 4")
+	expect(get("/items/1", "Cache-Control", "no-store"), "", "item 1 page  call 5")
+	expect(get("/items/1", "Pragma", "no-cache"), "MISS", "item 1 page  call 6")
+	if w := get("/items/3", "Cache-Control", "only-if-cached"); w.Code != 504 {
+		t.Errorf("expected 504 for only-if-cached, got %d", w.Code)
+	}
+
+	// response directives and statuses
+	expect(get("/items/4?private=1"), "MISS", "item 4 page  call 7")
+	expect(get("/items/4?private=1"), "MISS", "item 4 page  call 8")
+	get("/error")
+	get("/error")
+	expect(get("/items/5", "Authorization", "Bearer x"), "MISS", "item 5 page  call 11")
+	expect(get("/items/5", "Authorization", "Bearer x"), "MISS", "item 5 page  call 12")
+
+	// variants
+	calls.Store(0)
+	expect(get("/lang", "Accept-Language", "it"), "MISS", "it")
+	expect(get("/lang", "Accept-Language", "en"), "MISS", "en")
+	expect(get("/lang", "Accept-Language", "it"), "HIT", "it")
+	expect(get("/lang", "Accept-Language", "en"), "HIT", "en")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 calls for the variants, got %d", n)
+	}
+
+	stats := cache.Stats()
+	want := heligo.CacheStats{Hits: 6, Misses: 13, Bypasses: 1}
+	if stats != want {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+}
+
+func TestCacheHosts(t *testing.T) {
+	cache := heligo.NewResponseCache(heligo.CacheOptions{TTL: time.Minute})
+	router := heligo.New()
+	router.Use(heligo.Cache(cache))
+	router.Handle("GET", "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fmt.Fprint(w, r.Host)
+		return http.StatusOK, nil
+	})
+	tests := []struct {
+		host  string
+		state string
+		body  string
+	}{
+		{"a.example.com", "MISS", "a.example.com"},
+		{"b.example.com", "MISS", "b.example.com"},
+		{"a.example.com", "HIT", "a.example.com"},
+		// the port is ignored
+		{"b.example.com:8080", "HIT", "b.example.com"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Header().Get("X-Cache") != tt.state || w.Body.String() != tt.body {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.host, tt.state, tt.body, w.Header().Get("X-Cache"), w.Body)
+		}
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	cache := heligo.NewResponseCache(heligo.CacheOptions{})
+	router := heligo.New()
+	router.Use(heligo.Cache(cache))
+	var calls atomic.Int64
+	revalidated := make(chan struct{}, 1)
+	router.Handle("GET", "/news", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		fmt.Fprintf(w, "v%d", n)
+		if n > 1 {
+			select {
+			case revalidated <- struct{}{}:
+			default:
+			}
+		}
+		return http.StatusOK, nil
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/news", nil))
+		return w
+	}
+
+	get()
+	time.Sleep(1100 * time.Millisecond)
+	if w := get(); w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "v1" {
+		t.Errorf("expected the stale response, got %s %q", w.Header().Get("X-Cache"), w.Body)
+	}
+	<-revalidated
+	// wait for the store after the handler
+	for range 100 {
+		if w := get(); w.Header().Get("X-Cache") == "HIT" {
+			if w.Body.String() != "v2" {
+				t.Errorf("expected the revalidated response, got %q", w.Body)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := cache.Stats(); s.StaleHits < 1 || s.Misses != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	cache := heligo.NewResponseCache(heligo.CacheOptions{TTL: time.Minute})
+	router := heligo.New()
+	router.Use(heligo.Cache(cache))
+	var calls atomic.Int64
+	release := make(chan struct{})
+	router.Handle("GET", "/slow", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		calls.Add(1)
+		<-release
+		fmt.Fprint(w, "done")
+		return http.StatusOK, nil
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Go(func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+			bodies[i] = w.Body.String()
+		})
+	}
+	// let the requests reach the cache
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if c := calls.Load(); c != 1 {
+		t.Errorf("expected a single call, got %d", c)
+	}
+	if strings.Join(bodies, "") != strings.Repeat("done", n) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+	if s := cache.Stats(); s.Misses+s.Collapsed+s.Hits != n || s.Misses != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestCachePass(t *testing.T) {
+	cache := heligo.NewResponseCache(heligo.CacheOptions{TTL: time.Minute})
+	router := heligo.New()
+	router.Use(heligo.Cache(cache))
+	var running atomic.Int64
+	release := make(chan struct{})
+	router.Handle("GET", "/private", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		running.Add(1)
+		defer running.Add(-1)
+		if r.Header.Get("Wait") != "" {
+			<-release
+		}
+		w.Header().Set("Cache-Control", "private")
+		fmt.Fprint(w, "done")
+		return http.StatusOK, nil
+	})
+
+	// the first uncacheable response marks the resource to pass
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/private", nil))
+	const n = 5
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/private", nil)
+			r.Header.Set("Wait", "1")
+			router.ServeHTTP(w, r)
+			if w.Body.String() != "done" || w.Header().Get("X-Cache") != "MISS" {
+				t.Errorf("unexpected response %q %v", w.Body, w.Header())
+			}
+		})
+	}
+	// the misses run concurrently, instead of waiting for each other
+	deadline := time.Now().Add(time.Second)
+	for running.Load() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if r := running.Load(); r != n {
+		t.Errorf("expected %d concurrent requests, got %d", n, r)
+	}
+	close(release)
+	wg.Wait()
+	if s := cache.Stats(); s.Misses != n+1 || s.Collapsed != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	store := heligo.NewMemoryCacheStore(100)
+	ctx := context.Background()
+	future := time.Now().Add(time.Hour)
+	resp := func(body string) *heligo.CachedResponse {
+		return &heligo.CachedResponse{Status: 200, Body: []byte(body), StaleUntil: future}
+	}
+	store.Set(ctx, "a", resp(strings.Repeat("a", 40)))
+	store.Set(ctx, "b", resp(strings.Repeat("b", 40)))
+	store.Get(ctx, "a")
+	store.Set(ctx, "c", resp(strings.Repeat("c", 40)))
+	if _, ok := store.Get(ctx, "b"); ok {
+		t.Errorf("expected the least recently used entry to be evicted")
+	}
+	if _, ok := store.Get(ctx, "a"); !ok || store.Len() != 2 || store.Size() != 82 {
+		t.Errorf("unexpected entries %d of size %d", store.Len(), store.Size())
+	}
+	store.Set(ctx, "large", resp(strings.Repeat("l", 200)))
+	if _, ok := store.Get(ctx, "large"); ok {
+		t.Errorf("expected entries larger than the store not to be added")
+	}
+	store.Set(ctx, "expired", &heligo.CachedResponse{Status: 200, StaleUntil: time.Now().Add(-time.Second)})
+	if _, ok := store.Get(ctx, "expired"); ok {
+		t.Errorf("expected the expired entry to be removed")
+	}
+	store.Delete(ctx, "a")
+	if store.Len() != 1 {
+		t.Errorf("expected 1 entry, got %d", store.Len())
+	}
+}
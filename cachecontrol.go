@@ -0,0 +1,98 @@
+package heligo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheDirective is a directive of the Cache-Control header, see CacheControl
+type CacheDirective string
+
+// Cache-Control directives without arguments
+const (
+	Public          CacheDirective = "public"
+	Private         CacheDirective = "private"
+	NoCache         CacheDirective = "no-cache"
+	NoStore         CacheDirective = "no-store"
+	NoTransform     CacheDirective = "no-transform"
+	MustRevalidate  CacheDirective = "must-revalidate"
+	ProxyRevalidate CacheDirective = "proxy-revalidate"
+	Immutable       CacheDirective = "immutable"
+)
+
+// MaxAge is the time a response is fresh.
+func MaxAge(d time.Duration) CacheDirective {
+	return seconds("max-age", d)
+}
+
+// SMaxAge is the time a response is fresh in shared caches.
+func SMaxAge(d time.Duration) CacheDirective {
+	return seconds("s-maxage", d)
+}
+
+// StaleWhileRevalidate is the time a stale response can be served while
+// it is revalidated in the background.
+func StaleWhileRevalidate(d time.Duration) CacheDirective {
+	return seconds("stale-while-revalidate", d)
+}
+
+// StaleIfError is the time a stale response can be served when the
+// revalidation fails.
+func StaleIfError(d time.Duration) CacheDirective {
+	return seconds("stale-if-error", d)
+}
+
+// seconds formats a directive with a delta in seconds, rounded down
+func seconds(name string, d time.Duration) CacheDirective {
+	return CacheDirective(name + "=" + strconv.FormatInt(int64(max(d, 0)/time.Second), 10))
+}
+
+// CacheControl returns the Cache-Control header value with the directives.
+//
+//	w.Header().Set("Cache-Control", heligo.CacheControl(heligo.Public, heligo.MaxAge(time.Hour)))
+func CacheControl(directives ...CacheDirective) string {
+	var b strings.Builder
+	for i, d := range directives {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(string(d))
+	}
+	return b.String()
+}
+
+// cacheDirectives are the parsed directives of Cache-Control headers,
+// with lower case names and unquoted values
+type cacheDirectives map[string]string
+
+func parseCacheControl(values []string) cacheDirectives {
+	d := cacheDirectives{}
+	for _, v := range values {
+		for field := range strings.SplitSeq(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				d[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return d
+}
+
+func (d cacheDirectives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns the delta seconds of a directive, if valid
+func (d cacheDirectives) seconds(name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
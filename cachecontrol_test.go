@@ -0,0 +1,27 @@
+package heligo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		directives []heligo.CacheDirective
+		want       string
+	}{
+		{nil, ""},
+		{[]heligo.CacheDirective{heligo.NoStore}, "no-store"},
+		{[]heligo.CacheDirective{heligo.Public, heligo.MaxAge(time.Hour), heligo.Immutable}, "public, max-age=3600, immutable"},
+		{[]heligo.CacheDirective{heligo.Private, heligo.MaxAge(0), heligo.MustRevalidate}, "private, max-age=0, must-revalidate"},
+		{[]heligo.CacheDirective{heligo.SMaxAge(90 * time.Second), heligo.StaleWhileRevalidate(1500 * time.Millisecond), heligo.StaleIfError(-time.Second)},
+			"s-maxage=90, stale-while-revalidate=1, stale-if-error=0"},
+	}
+	for _, tt := range tests {
+		if got := heligo.CacheControl(tt.directives...); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
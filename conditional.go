@@ -0,0 +1,235 @@
+package heligo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckPreconditions evaluates the conditional headers of the request
+// against the current etag and last modification time of the resource,
+// in the order of RFC 9110: If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since. It returns 304 Not Modified or 412 Precondition
+// Failed when the request must not proceed, 0 otherwise.
+// An empty etag or a zero lastModified are unknown: the resource exists,
+// so an empty etag matches "*" and no other entity tag.
+//
+//	w.Header().Set("ETag", etag)
+//	if status := r.CheckPreconditions(etag, doc.Updated); status != 0 {
+//		return heligo.WriteHeader(w, status)
+//	}
+func (r *Request) CheckPreconditions(etag string, lastModified time.Time) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	lastModified = lastModified.Truncate(time.Second)
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && safe && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// matchETag reports whether the list of entity tags of a conditional
+// header matches etag, with the weak or the strong comparison.
+// "*" matches any current etag, even unknown.
+func matchETag(list string, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+	opaque, isWeak := strings.CutPrefix(etag, "W/")
+	if isWeak && !weak {
+		return false
+	}
+	for tag := range strings.SplitSeq(list, ",") {
+		tag = strings.TrimSpace(tag)
+		t, tagWeak := strings.CutPrefix(tag, "W/")
+		if tagWeak && !weak {
+			continue
+		}
+		if t == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified writes a 304 response, removing the headers describing
+// the body, that it must not have
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// DefaultETagMaxSize is the default size of the responses buffered by ETag
+const DefaultETagMaxSize = 64 << 10
+
+// ETagOptions configures the ETag middleware.
+type ETagOptions struct {
+	// Weak generates weak etags, W/"...", for responses that are
+	// semantically equivalent but not byte for byte, e.g. compressed later
+	Weak bool
+	// MaxSize is the size of the largest response buffered,
+	// DefaultETagMaxSize if zero: larger responses are sent without etag
+	MaxSize int
+}
+
+// ETag returns a middleware buffering the 200 responses to GET and HEAD
+// requests, setting their ETag to a hash of the body if the handler
+// didn't set one, and answering the conditional requests with 304 or
+// 412, see CheckPreconditions. Responses flushed by the handlers are
+// streamed unchanged.
+func ETag(opts ETagOptions) Middleware {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultETagMaxSize
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				return next(ctx, w, r)
+			}
+			ew := &etagWriter{ResponseWriter: NewResponseWriter(w), max: opts.MaxSize}
+			status, err := next(ctx, ew, r)
+			if ew.passthrough || ew.status == 0 {
+				return status, err
+			}
+			if err != nil {
+				ew.stream()
+				return status, err
+			}
+
+			h := w.Header()
+			etag := h.Get("ETag")
+			if etag == "" {
+				sum := sha256.Sum256(ew.buf.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				if opts.Weak {
+					etag = "W/" + etag
+				}
+				h.Set("ETag", etag)
+			}
+			lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+			switch precondition := r.CheckPreconditions(etag, lastModified); precondition {
+			case http.StatusNotModified:
+				writeNotModified(w)
+				return precondition, nil
+			case http.StatusPreconditionFailed:
+				w.WriteHeader(precondition)
+				return precondition, nil
+			}
+			ew.stream()
+			return status, err
+		}
+	}
+}
+
+// etagWriter buffers a 200 response up to max bytes, then streams it
+type etagWriter struct {
+	*ResponseWriter
+	max         int
+	status      int // the status buffered, 0 if none
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	switch {
+	case w.passthrough:
+		w.ResponseWriter.WriteHeader(code)
+	case code >= 100 && code < 200 && code != http.StatusSwitchingProtocols:
+		w.ResponseWriter.WriteHeader(code)
+	case w.status == 0:
+		w.status = code
+		if code != http.StatusOK {
+			w.stream()
+		}
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passthrough && w.buf.Len()+len(b) > w.max {
+		w.stream()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// stream writes the header and the buffered body, switching to passthrough
+func (w *etagWriter) stream() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	if w.status == 0 {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 && bodyAllowedForStatus(w.status) {
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+}
+
+// Flush streams the response.
+func (w *etagWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.stream()
+	w.ResponseWriter.Flush()
+}
+
+// Hijack streams the buffered response and lets the caller take over
+// the connection.
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.stream()
+	return w.ResponseWriter.Hijack()
+}
+
+// ReadFrom copies r to the response, buffering it through Write until
+// streamed.
+func (w *etagWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.passthrough {
+		return w.ResponseWriter.ReadFrom(r)
+	}
+	// hide ReadFrom from io.Copy, to avoid the recursion
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
@@ -0,0 +1,157 @@
+package heligo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	same := modified.Format(http.TimeFormat)
+	etag := `"v2"`
+
+	tests := []struct {
+		method  string
+		headers map[string]string
+		etag    string
+		status  int
+	}{
+		{"GET", nil, etag, 0},
+		{"GET", map[string]string{"If-None-Match": `"v1", "v2"`}, etag, 304},
+		{"GET", map[string]string{"If-None-Match": `W/"v2"`}, etag, 304},
+		{"GET", map[string]string{"If-None-Match": `"v2"`}, `W/"v2"`, 304},
+		{"GET", map[string]string{"If-None-Match": `"v1"`}, etag, 0},
+		{"GET", map[string]string{"If-None-Match": "*"}, etag, 304},
+		{"GET", map[string]string{"If-None-Match": "*"}, "", 304},
+		{"GET", map[string]string{"If-None-Match": `"v2"`}, "", 0},
+		{"HEAD", map[string]string{"If-None-Match": `"v2"`}, etag, 304},
+		{"PUT", map[string]string{"If-None-Match": `"v2"`}, etag, 412},
+		{"PUT", map[string]string{"If-None-Match": "*"}, "", 412},
+		{"PUT", map[string]string{"If-Match": `"v1", "v2"`}, etag, 0},
+		{"PUT", map[string]string{"If-Match": `"v1"`}, etag, 412},
+		// If-Match uses the strong comparison
+		{"PUT", map[string]string{"If-Match": `W/"v2"`}, etag, 412},
+		{"PUT", map[string]string{"If-Match": `"v2"`}, `W/"v2"`, 412},
+		{"PUT", map[string]string{"If-Match": "*"}, "", 0},
+		{"PUT", map[string]string{"If-Match": `"v2"`}, "", 412},
+		{"GET", map[string]string{"If-Modified-Since": same}, etag, 304},
+		{"GET", map[string]string{"If-Modified-Since": before}, etag, 0},
+		{"GET", map[string]string{"If-Modified-Since": "garbage"}, etag, 0},
+		{"POST", map[string]string{"If-Modified-Since": same}, etag, 0},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"GET", map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": same}, etag, 0},
+		{"PUT", map[string]string{"If-Unmodified-Since": before}, etag, 412},
+		{"PUT", map[string]string{"If-Unmodified-Since": same}, etag, 0},
+		// If-Match takes precedence over If-Unmodified-Since
+		{"PUT", map[string]string{"If-Match": `"v2"`, "If-Unmodified-Since": before}, etag, 0},
+	}
+	for _, tt := range tests {
+		router := heligo.New()
+		var status int
+		router.Handle(tt.method, "/doc", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			status = r.CheckPreconditions(tt.etag, modified)
+			return http.StatusOK, nil
+		})
+		req := httptest.NewRequest(tt.method, "/doc", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if status != tt.status {
+			t.Errorf("%s %v with etag %s: expected %d, got %d", tt.method, tt.headers, tt.etag, tt.status, status)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	router := heligo.New()
+	var status int
+	router.Use(func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			status, _ = next(ctx, w, r)
+			return status, nil
+		}
+	})
+	router.Use(heligo.ETag(heligo.ETagOptions{MaxSize: 100}))
+	router.Handle("GET", "/small", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", heligo.CacheControl(heligo.NoCache))
+		w.Write([]byte("hello "))
+		w.Write([]byte("world"))
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/large", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Write([]byte(strings.Repeat("x", 150)))
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/own", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Header().Set("ETag", `"mine"`)
+		w.Write([]byte("body"))
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/missing", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		http.Error(w, "not here", http.StatusNotFound)
+		return http.StatusNotFound, nil
+	})
+	router.Handle("GET", "/flushed", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Write([]byte("part"))
+		http.NewResponseController(w).Flush()
+		return http.StatusOK, nil
+	})
+
+	serve := func(path string, inm string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/small", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != "hello world" || !strings.HasPrefix(etag, `"`) || len(etag) != 34 {
+		t.Fatalf("unexpected response %d %q with etag %q", w.Code, w.Body, etag)
+	}
+	w = serve("/small", etag)
+	if w.Code != 304 || status != 304 || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" ||
+		w.Header().Get("ETag") != etag || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected 304 without body and content type, got %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if w = serve("/small", `"other"`); w.Code != 200 || w.Body.String() != "hello world" {
+		t.Errorf("expected 200 for another etag, got %d", w.Code)
+	}
+
+	if w = serve("/large", ""); w.Code != 200 || w.Body.Len() != 150 || w.Header().Get("ETag") != "" {
+		t.Errorf("expected the large response streamed without etag, got %d %d %q", w.Code, w.Body.Len(), w.Header().Get("ETag"))
+	}
+	if w = serve("/own", `"mine"`); w.Code != 304 {
+		t.Errorf("expected 304 for the etag of the handler, got %d", w.Code)
+	}
+	if w = serve("/missing", ""); w.Code != 404 || w.Header().Get("ETag") != "" || !strings.Contains(w.Body.String(), "not here") {
+		t.Errorf("expected 404 without etag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w = serve("/flushed", ""); w.Code != 200 || !w.Flushed || w.Header().Get("ETag") != "" || w.Body.String() != "part" {
+		t.Errorf("expected the flushed response streamed, got %d %v", w.Code, w.Flushed)
+	}
+
+	weak := heligo.New()
+	weak.Use(heligo.ETag(heligo.ETagOptions{Weak: true}))
+	weak.Handle("GET", "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Write([]byte("body"))
+		return http.StatusOK, nil
+	})
+	w = httptest.NewRecorder()
+	weak.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if etag := w.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("expected a weak etag, got %q", etag)
+	}
+}
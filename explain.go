@@ -0,0 +1,160 @@
+package heligo
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Explanation describes how the router selects a route for a request.
+type Explanation struct {
+	Method string
+	Path   string // the routed path
+	Host   string
+	// Pattern is the pattern of the selected route, empty if none is found,
+	// and RouteHost its host pattern, empty for the default host
+	Pattern   string
+	RouteHost string
+	Params    []Param
+	// Tried lists the other routes matching the path, with the reason
+	// they were not selected
+	Tried []Candidate
+}
+
+// Candidate is a route matching the path that was not selected.
+type Candidate struct {
+	Method  string
+	Host    string
+	Pattern string
+	Reason  string
+}
+
+// Explain reports the route selected for method and path, and the
+// routes rejected for it. path can be a full URL, to select a host.
+// It's meant for debugging and for tests, not for serving requests.
+func (router *Router) Explain(method string, path string) Explanation {
+	r, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return Explanation{Method: method, Path: path}
+	}
+	return router.ExplainRequest(r)
+}
+
+// ExplainRequest is as Explain, taking into account all the request,
+// including the headers for the route matchers.
+func (router *Router) ExplainRequest(r *http.Request) Explanation {
+	req := Request{Request: r, path: r.URL.Path}
+	if router.UseRawPath {
+		req.path = r.URL.EscapedPath()
+		req.params.escaped = true
+	}
+	req.params.nonEmpty = router.RejectEmptyParams
+	e := Explanation{Method: r.Method, Path: req.path, Host: r.Host}
+	selected := router.getRoute(r, req.path, 0, &req.params)
+	if selected != nil {
+		e.Pattern, e.RouteHost = selected.Pattern, selected.Host
+		e.Params = req.Params()
+	}
+
+	// without a selected route, the first route with unsatisfied matchers
+	// shadows the following ones
+	var shadow *route
+	t := router.table.Load()
+	for _, rs := range append(slices.Clone(t.hosts), &t.routes) {
+		own := rs.tree(r.Method)
+		hostOK := rs.host == "" || matchHost(rs, r.Host, &params{})
+		for _, tree := range rs.methodTrees(r.Method) {
+			tree.n.walk(func(rt *route) {
+				if rt == selected || !router.matchesPath(rt, req.path) {
+					return
+				}
+				c := Candidate{Method: tree.method, Host: rt.Host, Pattern: rt.Pattern}
+				switch {
+				case tree.n != own:
+					c.Reason = "method does not match"
+				case !hostOK:
+					c.Reason = "host does not match"
+				case !rt.matches(r):
+					c.Reason = "matchers not satisfied"
+					if shadow == nil {
+						shadow = rt
+					}
+				case selected != nil:
+					c.Reason = "lower precedence than " + selected.Pattern
+				case shadow != nil:
+					c.Reason = "shadowed by " + shadow.Pattern + ", whose matchers are not satisfied"
+				default:
+					c.Reason = "not reached by the lookup"
+				}
+				e.Tried = append(e.Tried, c)
+			})
+		}
+	}
+	return e
+}
+
+type methodTree struct {
+	method string
+	n      *node
+}
+
+// methodTrees returns the trees of rs, the one for method first
+// and then the others sorted by method.
+func (rs *routes) methodTrees(method string) []methodTree {
+	var trees []methodTree
+	if rs.get != nil {
+		trees = append(trees, methodTree{http.MethodGet, rs.get})
+	}
+	for _, m := range slices.Sorted(maps.Keys(rs.trees)) {
+		trees = append(trees, methodTree{m, rs.trees[m]})
+	}
+	own := rs.tree(method)
+	slices.SortStableFunc(trees, func(a, b methodTree) int {
+		if a.n == own && b.n != own {
+			return -1
+		}
+		if b.n == own && a.n != own {
+			return 1
+		}
+		return 0
+	})
+	return trees
+}
+
+// walk calls f once for each route in the tree, in precedence order
+func (n *node) walk(f func(*route)) {
+	seen := map[*route]bool{}
+	var visit func(n *node)
+	visit = func(n *node) {
+		if n == nil {
+			return
+		}
+		for _, rt := range append(slices.Clone(n.routes), n.route) {
+			if rt != nil && !seen[rt] {
+				seen[rt] = true
+				f(rt)
+			}
+		}
+		for _, child := range n.children {
+			visit(child)
+		}
+		for _, child := range n.childRe {
+			visit(child)
+		}
+		visit(n.childColon)
+		visit(n.childStar)
+	}
+	visit(n)
+}
+
+// matchesPath reports whether the pattern of rt, registered alone,
+// matches path.
+func (router *Router) matchesPath(rt *route, path string) bool {
+	rs := &routes{trees: map[string]*node{}}
+	for _, p := range router.variants(rt.Pattern) {
+		rs.addRoute("GET", p, rt)
+	}
+	p := params{nonEmpty: router.RejectEmptyParams}
+	n := rs.get.findNode(path, 0, &p)
+	return n != nil && n.hasHandler()
+}
@@ -0,0 +1,70 @@
+package heligo_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+func TestExplain(t *testing.T) {
+	h := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	}
+	router := heligo.New()
+	router.Handle("GET", "/users/me", h, heligo.MatchHeader("X-Me", "1"))
+	router.Handle("GET", "/users/:id", h)
+	router.Handle("GET", "/users/:id{[0-9]+}/posts", h)
+	router.Handle("GET", "/*path", h)
+	router.Handle("DELETE", "/users/:id", h)
+	router.Host("api.example.com").Handle("GET", "/users/:name", h)
+
+	e := router.Explain("GET", "/users/7")
+	if e.Pattern != "/users/:id" || e.RouteHost != "" {
+		t.Fatalf("expected /users/:id, got %q %q", e.RouteHost, e.Pattern)
+	}
+	if want := []heligo.Param{{Name: "id", Value: "7"}}; !reflect.DeepEqual(e.Params, want) {
+		t.Errorf("expected params %v, got %v", want, e.Params)
+	}
+	want := []heligo.Candidate{
+		{"GET", "api.example.com", "/users/:name", "host does not match"},
+		{"GET", "", "/*path", "lower precedence than /users/:id"},
+		{"DELETE", "", "/users/:id", "method does not match"},
+	}
+	if !reflect.DeepEqual(e.Tried, want) {
+		t.Errorf("expected candidates\n%v\ngot\n%v", want, e.Tried)
+	}
+
+	// the node of /users/me is selected, but its matchers fail
+	e = router.Explain("GET", "/users/me")
+	if e.Pattern != "" {
+		t.Errorf("expected no match, got %q", e.Pattern)
+	}
+	want = []heligo.Candidate{
+		{"GET", "api.example.com", "/users/:name", "host does not match"},
+		{"GET", "", "/users/me", "matchers not satisfied"},
+		{"GET", "", "/users/:id", "shadowed by /users/me, whose matchers are not satisfied"},
+		{"GET", "", "/*path", "shadowed by /users/me, whose matchers are not satisfied"},
+		{"DELETE", "", "/users/:id", "method does not match"},
+	}
+	if !reflect.DeepEqual(e.Tried, want) {
+		t.Errorf("expected candidates\n%v\ngot\n%v", want, e.Tried)
+	}
+
+	e = router.Explain("GET", "http://api.example.com/users/7")
+	if e.Pattern != "/users/:name" || e.RouteHost != "api.example.com" || e.Params[0].Value != "7" {
+		t.Errorf("expected the host route, got %+v", e)
+	}
+
+	r, _ := http.NewRequest("GET", "/users/me", nil)
+	r.Header.Set("X-Me", "1")
+	if e = router.ExplainRequest(r); e.Pattern != "/users/me" {
+		t.Errorf("expected /users/me, got %q", e.Pattern)
+	}
+
+	if e = router.Explain("POST", "/users/7"); e.Pattern != "" || len(e.Tried) != 4 {
+		t.Errorf("expected no match and 4 candidates, got %+v", e)
+	}
+}
@@ -0,0 +1,277 @@
+package heligo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOptions configures ServeFiles and FileServer.
+type FileOptions struct {
+	// Index is the file served for directories, "index.html" if empty
+	Index string
+	// Browse lists the directories without an index file,
+	// otherwise they are not found
+	Browse bool
+	// SPA serves the root index file for missing paths without an extension,
+	// for client side routing
+	SPA bool
+	// Precompressed serves the ".br" or ".gz" sibling of a file,
+	// if present and accepted by the client
+	Precompressed bool
+	// MaxAge sets the Cache-Control max-age of regular files;
+	// index files are always revalidated
+	MaxAge time.Duration
+	// Immutable reports whether a file name contains a content hash, to
+	// be cached for one year; by default names like "app.3f2a9c1b.js"
+	Immutable func(name string) bool
+}
+
+var hashedName = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[^/]+$`)
+
+// ServeFiles serves the files of fsys under pattern, that must end with
+// a wildcard parameter, e.g. "/static/*filepath".
+// The parameter is the path of the file in fsys. The root of fsys is
+// served at the prefix, e.g. "/static/".
+func (router *Router) ServeFiles(pattern string, fsys fs.FS, opts FileOptions) {
+	i := strings.LastIndexByte(pattern, SLASH)
+	if i < 0 || i+1 >= len(pattern) || pattern[i+1] != STAR {
+		panic("heligo: ServeFiles pattern must end with a wildcard parameter: " + pattern)
+	}
+	fsrv := newFileServer(fsys, opts)
+	router.Handle(http.MethodGet, pattern, fsrv.serve)
+	// a wildcard doesn't match an empty value
+	router.Handle(http.MethodGet, pattern[:i+1], func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+		return fsrv.serveName(w, r, ".")
+	})
+}
+
+// FileServer returns a handler serving the files of fsys.
+// The file path is the value of the last route parameter, or the request
+// path if the route has none.
+// Conditional requests (ETag and Last-Modified) and ranges are supported.
+func FileServer(fsys fs.FS, opts FileOptions) Handler {
+	return newFileServer(fsys, opts).serve
+}
+
+func newFileServer(fsys fs.FS, opts FileOptions) *fileServer {
+	fsrv := &fileServer{fsys: fsys, opts: opts}
+	if fsrv.opts.Index == "" {
+		fsrv.opts.Index = "index.html"
+	}
+	if fsrv.opts.Immutable == nil {
+		fsrv.opts.Immutable = hashedName.MatchString
+	}
+	return fsrv
+}
+
+type fileServer struct {
+	fsys fs.FS
+	opts FileOptions
+	// content hashes for files without modification time (embed.FS)
+	etags sync.Map
+}
+
+func (fsrv *fileServer) serve(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+	var name string
+	if r.params.count > 0 {
+		name = r.unescapedValue(int(r.params.count) - 1)
+	} else {
+		name = r.URL.Path
+	}
+	name = strings.Trim(name, "/")
+	if name == "" {
+		name = "."
+	}
+	return fsrv.serveName(w, r, name)
+}
+
+// serveName serves the file or directory name of fsys
+func (fsrv *fileServer) serveName(w http.ResponseWriter, r Request, name string) (int, error) {
+	// reject traversals instead of cleaning them
+	if !fs.ValidPath(name) || strings.ContainsRune(name, '\\') {
+		return notFound(w, r)
+	}
+
+	info, err := fs.Stat(fsrv.fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && fsrv.opts.SPA && path.Ext(name) == "" {
+			return fsrv.serveFile(w, r, fsrv.opts.Index)
+		}
+		return fsrv.fsError(w, r, err)
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// relative, as the path can be stripped by Mount
+			u := url.URL{Path: path.Base(r.URL.Path) + "/", RawQuery: r.URL.RawQuery}
+			w.Header().Set("Location", u.String())
+			w.WriteHeader(http.StatusMovedPermanently)
+			return http.StatusMovedPermanently, nil
+		}
+		index := path.Join(name, fsrv.opts.Index)
+		if _, err := fs.Stat(fsrv.fsys, index); err == nil {
+			return fsrv.serveFile(w, r, index)
+		}
+		if fsrv.opts.Browse {
+			return fsrv.serveDir(w, name)
+		}
+		return notFound(w, r)
+	}
+	return fsrv.serveFile(w, r, name)
+}
+
+func notFound(w http.ResponseWriter, r Request) (int, error) {
+	http.NotFound(w, r.Request)
+	return http.StatusNotFound, nil
+}
+
+func (fsrv *fileServer) fsError(w http.ResponseWriter, r Request, err error) (int, error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return notFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return http.StatusForbidden, nil
+	}
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	return http.StatusInternalServerError, err
+}
+
+// encodings are the precompressed variants, in order of preference
+var encodings = []struct{ name, ext string }{{"br", ".br"}, {"gzip", ".gz"}}
+
+func (fsrv *fileServer) serveFile(w http.ResponseWriter, r Request, name string) (int, error) {
+	h := w.Header()
+	served, encoding := name, ""
+	if fsrv.opts.Precompressed {
+		h.Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if acceptsEncoding(r.Header.Get("Accept-Encoding"), enc.name) {
+				if info, err := fs.Stat(fsrv.fsys, name+enc.ext); err == nil && info.Mode().IsRegular() {
+					served, encoding = name+enc.ext, enc.name
+					break
+				}
+			}
+		}
+	}
+
+	f, err := fsrv.fsys.Open(served)
+	if err != nil {
+		return fsrv.fsError(w, r, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fsrv.fsError(w, r, err)
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return fsrv.fsError(w, r, err)
+		}
+		content = bytes.NewReader(b)
+	}
+
+	etag, err := fsrv.etag(served, info, content)
+	if err != nil {
+		return fsrv.fsError(w, r, err)
+	}
+	h.Set("ETag", etag)
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		// the type must come from the original name, not from the sniffed content
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h.Set("Content-Type", ctype)
+	}
+	switch base := path.Base(name); {
+	case base == fsrv.opts.Index:
+		h.Set("Cache-Control", "no-cache")
+	case fsrv.opts.Immutable(base):
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case fsrv.opts.MaxAge > 0:
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(fsrv.opts.MaxAge.Seconds())))
+	}
+
+	rw := NewResponseWriter(w)
+	http.ServeContent(rw, r.Request, name, info.ModTime(), content)
+	return rw.Status(), nil
+}
+
+// etag returns a strong validator from the modification time and size,
+// or from the content if the modification time is unknown.
+func (fsrv *fileServer) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	if etag, ok := fsrv.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	fsrv.etags.Store(name, etag)
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows enc
+func acceptsEncoding(header, enc string) bool {
+	for part := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), enc) {
+			continue
+		}
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+func (fsrv *fileServer) serveDir(w http.ResponseWriter, name string) (int, error) {
+	entries, err := fs.ReadDir(fsrv.fsys, name)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<pre>\n")
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		u := url.URL{Path: n}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(n))
+	}
+	b.WriteString("</pre>\n")
+	io.WriteString(w, b.String())
+	return http.StatusOK, nil
+}
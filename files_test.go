@@ -0,0 +1,106 @@
+package heligo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestServeFiles(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":          {Data: []byte("<html>app</html>")},
+		"app.3f2a9c1b.js":     {Data: []byte("console.log(1)"), ModTime: modTime},
+		"style.css":           {Data: []byte("body{}"), ModTime: modTime},
+		"style.css.br":        {Data: []byte("brotli"), ModTime: modTime},
+		"style.css.gz":        {Data: []byte("gzip"), ModTime: modTime},
+		"docs/index.html":     {Data: []byte("docs")},
+		"assets/logo.svg":     {Data: []byte("<svg/>")},
+		"assets/a&b.txt":      {Data: []byte("ab")},
+		"assets/sub/file.txt": {Data: []byte("sub")},
+	}
+	router := heligo.New()
+	router.ServeFiles("/static/*filepath", fsys, heligo.FileOptions{Precompressed: true, MaxAge: time.Hour, Browse: true})
+	spa := heligo.New()
+	spa.ServeFiles("/*filepath", fsys, heligo.FileOptions{SPA: true})
+	mounted := heligo.New()
+	mounted.Mount("/files", spa)
+
+	tests := []struct {
+		router  *heligo.Router
+		url     string
+		headers map[string]string
+		status  int
+		body    string
+		check   map[string]string
+	}{
+		{router, "/static/style.css", nil, 200, "body{}", map[string]string{"Cache-Control": "public, max-age=3600", "Last-Modified": "Tue, 02 Jan 2024 03:04:05 GMT", "Vary": "Accept-Encoding"}},
+		{router, "/static/style.css", map[string]string{"Accept-Encoding": "gzip, br"}, 200, "brotli", map[string]string{"Content-Encoding": "br", "Content-Type": "text/css; charset=utf-8"}},
+		{router, "/static/style.css", map[string]string{"Accept-Encoding": "gzip, br;q=0"}, 200, "gzip", map[string]string{"Content-Encoding": "gzip"}},
+		{router, "/static/style.css", map[string]string{"Range": "bytes=0-3"}, 206, "body", nil},
+		{router, "/static/style.css", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:05 GMT"}, 304, "", nil},
+		{router, "/static/app.3f2a9c1b.js", nil, 200, "console.log(1)", map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}},
+		{router, "/static/index.html", nil, 200, "<html>app</html>", map[string]string{"Cache-Control": "no-cache"}},
+		{router, "/static/", nil, 200, "<html>app</html>", map[string]string{"Cache-Control": "no-cache"}},
+		{router, "/static/docs", nil, 301, "", map[string]string{"Location": "docs/"}},
+		{mounted, "/files/docs", nil, 301, "", map[string]string{"Location": "docs/"}},
+		{mounted, "/files/docs/", nil, 200, "docs", nil},
+		{router, "/static/docs/", nil, 200, "docs", nil},
+		{router, "/static/assets/", nil, 200, "<!doctype html>\n<pre>\n<a href=\"a&amp;b.txt\">a&amp;b.txt</a>\n<a href=\"logo.svg\">logo.svg</a>\n<a href=\"sub/\">sub/</a>\n</pre>\n", nil},
+		{router, "/static/../go.mod", nil, 404, "", nil},
+		{router, "/static/assets/../index.html", nil, 404, "", nil},
+		{router, "/static/missing", nil, 404, "", nil},
+		{spa, "/", nil, 200, "<html>app</html>", nil},
+		{spa, "/users/42", nil, 200, "<html>app</html>", nil},
+		{spa, "/missing.js", nil, 404, "", nil},
+		{spa, "/assets/logo.svg", nil, 200, "<svg/>", nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		// keep the dot segments, as a raw client would send them
+		r.URL.Path = test.url
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		test.router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %v: expected %d, got %d", test.url, test.headers, test.status, w.Code)
+			continue
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %v: expected %q, got %q", test.url, test.headers, test.body, w.Body.String())
+		}
+		for k, v := range test.check {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s %v: expected %s %q, got %q", test.url, test.headers, k, v, got)
+			}
+		}
+	}
+}
+
+func TestServeFilesETag(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("hello")}}
+	router := heligo.New()
+	router.ServeFiles("/*filepath", fsys, heligo.FileOptions{})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/a.txt", nil)
+	router.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" {
+		t.Fatalf("expected 200 with ETag, got %d %q", w.Code, etag)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/a.txt", nil)
+	r.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+}
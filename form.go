@@ -0,0 +1,412 @@
+package heligo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Default form limits, see FormOptions
+const (
+	DefaultMaxFormSize = 32 << 20
+	DefaultFormMemory  = 1 << 20
+	DefaultMaxParts    = 1000
+)
+
+// sniffLen is the number of bytes used by http.DetectContentType
+const sniffLen = 512
+
+var (
+	// ErrFormTooLarge is wrapped by the FormErrors of the exceeded limits
+	ErrFormTooLarge = errors.New("too large")
+	// ErrUnsupportedType is wrapped by the FormErrors of the content types
+	// not allowed
+	ErrUnsupportedType = errors.New("unsupported content type")
+)
+
+// FormOptions limits the reading of forms and uploads.
+type FormOptions struct {
+	// MaxSize limits the whole body, DefaultMaxFormSize if zero
+	MaxSize int64
+	// MaxFileSize limits each file, if positive
+	MaxFileSize int64
+	// MaxParts limits the number of multipart parts, DefaultMaxParts if zero
+	MaxParts int
+	// AllowedTypes are the accepted content types of the files, as
+	// "image/png" or "image/*", all if empty. They are matched against the
+	// type sniffed from the content with http.DetectContentType, not the
+	// one declared by the client.
+	AllowedTypes []string
+	// MaxMemory is the memory used by ReadForm for the values and the
+	// files, DefaultFormMemory if zero: files not fitting are written to
+	// temporary files in TempDir, or the default directory if empty.
+	// With NoTempFiles they are rejected instead.
+	MaxMemory   int64
+	TempDir     string
+	NoTempFiles bool
+}
+
+func (opts FormOptions) withDefaults() FormOptions {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxFormSize
+	}
+	if opts.MaxParts <= 0 {
+		opts.MaxParts = DefaultMaxParts
+	}
+	if opts.MaxMemory <= 0 {
+		opts.MaxMemory = DefaultFormMemory
+	}
+	return opts
+}
+
+// FormError is returned when reading a form fails, with the status to
+// return to the client: 413 for the exceeded limits, 415 for the content
+// types not allowed, 400 for malformed forms and 500 for the failures of
+// the temporary files.
+type FormError struct {
+	Status int
+	Field  string // the field of the failing part, if any
+	Err    error
+}
+
+func (e *FormError) Error() string {
+	if e.Field != "" {
+		return "heligo: form field " + strconv.Quote(e.Field) + ": " + e.Err.Error()
+	}
+	return "heligo: form: " + e.Err.Error()
+}
+
+func (e *FormError) Unwrap() error {
+	return e.Err
+}
+
+// FormStatus returns the status of a FormError, or 400 for other errors,
+// to be returned by the handlers to the ErrorHandler:
+//
+//	form, err := r.ReadForm(heligo.FormOptions{MaxFileSize: 10 << 20})
+//	if err != nil {
+//		return heligo.FormStatus(err), err
+//	}
+//	defer form.RemoveAll()
+func FormStatus(err error) int {
+	if fe, ok := errors.AsType[*FormError](err); ok {
+		return fe.Status
+	}
+	return http.StatusBadRequest
+}
+
+// formError converts the body limit errors to FormErrors
+func formError(field string, err error) error {
+	if _, ok := errors.AsType[*FormError](err); ok {
+		return err
+	}
+	if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+		return &FormError{http.StatusRequestEntityTooLarge, field, ErrFormTooLarge}
+	}
+	return &FormError{http.StatusBadRequest, field, err}
+}
+
+// Part is a part of a multipart form, see Request.Parts.
+type Part struct {
+	FormName string
+	FileName string // empty for the values
+	// ContentType is the sniffed type for the files, the declared
+	// one for the values
+	ContentType string
+	Header      textproto.MIMEHeader
+	r           io.Reader
+	size        int64
+	max         int64
+}
+
+// IsFile reports whether the part is a file.
+func (p *Part) IsFile() bool {
+	return p.FileName != ""
+}
+
+// Read reads the content of the part, failing with a FormError when
+// exceeding the limits.
+func (p *Part) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.size += int64(n)
+	if p.max > 0 && p.size > p.max {
+		n -= int(p.size - p.max)
+		p.size = p.max
+		return n, &FormError{http.StatusRequestEntityTooLarge, p.FormName, ErrFormTooLarge}
+	}
+	if err != nil && err != io.EOF {
+		err = formError(p.FormName, err)
+	}
+	return n, err
+}
+
+// Parts iterates over the parts of a multipart/form-data body, streaming
+// their content: each part must be read before the next iteration.
+// The iteration ends after an error, a *FormError.
+//
+//	for part, err := range r.Parts(heligo.FormOptions{MaxFileSize: 1 << 30}) {
+//		if err != nil {
+//			return heligo.FormStatus(err), err
+//		}
+//		if part.IsFile() {
+//			if _, err := store.Save(part.FileName, part); err != nil {
+//				return heligo.FormStatus(err), err
+//			}
+//		}
+//	}
+func (r *Request) Parts(opts FormOptions) iter.Seq2[*Part, error] {
+	opts = opts.withDefaults()
+	return func(yield func(*Part, error) bool) {
+		mr, err := r.multipartReader(opts)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for count := 0; ; count++ {
+			mp, err := mr.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err == nil && count == opts.MaxParts {
+				err = &FormError{http.StatusRequestEntityTooLarge, "", errors.New("too many parts")}
+			}
+			if err != nil {
+				yield(nil, formError("", err))
+				return
+			}
+			p, err := newPart(mp, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(p, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Request) multipartReader(opts FormOptions) (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, &FormError{http.StatusUnsupportedMediaType, "", ErrUnsupportedType}
+	}
+	if params["boundary"] == "" {
+		return nil, &FormError{http.StatusBadRequest, "", errors.New("missing boundary")}
+	}
+	body, err := r.limitBody(opts.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewReader(body, params["boundary"]), nil
+}
+
+// limitBody limits the body to max bytes, failing early on the Content-Length
+func (r *Request) limitBody(max int64) (io.Reader, error) {
+	if r.ContentLength > max {
+		return nil, &FormError{http.StatusRequestEntityTooLarge, "", ErrFormTooLarge}
+	}
+	if r.Body == nil {
+		return http.NoBody, nil
+	}
+	return http.MaxBytesReader(nil, r.Body, max), nil
+}
+
+func newPart(mp *multipart.Part, opts FormOptions) (*Part, error) {
+	p := &Part{
+		FormName:    mp.FormName(),
+		FileName:    mp.FileName(),
+		ContentType: mp.Header.Get("Content-Type"),
+		Header:      mp.Header,
+		r:           mp,
+	}
+	if !p.IsFile() {
+		return p, nil
+	}
+	p.max = opts.MaxFileSize
+	br := bufio.NewReaderSize(mp, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, formError(p.FormName, err)
+	}
+	p.ContentType = http.DetectContentType(head)
+	p.r = br
+	if !typeAllowed(opts.AllowedTypes, p.ContentType) {
+		return nil, &FormError{http.StatusUnsupportedMediaType, p.FormName, ErrUnsupportedType}
+	}
+	return p, nil
+}
+
+// typeAllowed matches the media type of ct with the allowed types
+func typeAllowed(allowed []string, ct string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(ct, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType || a == "*/*" ||
+			strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, a[:len(a)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Form is a form read by Request.ReadForm.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*FormFile
+}
+
+// Value returns the first value of the field, empty if none.
+func (f *Form) Value(name string) string {
+	return f.Values.Get(name)
+}
+
+// File returns the first file of the field, nil if none.
+func (f *Form) File(name string) *FormFile {
+	if files := f.Files[name]; len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// RemoveAll removes the temporary files.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.path != "" {
+				if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FormFile is an uploaded file, kept in memory or in a temporary file.
+type FormFile struct {
+	FileName    string
+	ContentType string // sniffed from the content
+	Header      textproto.MIMEHeader
+	Size        int64
+	data        []byte
+	path        string
+}
+
+// InMemory reports whether the file is kept in memory.
+func (f *FormFile) InMemory() bool {
+	return f.path == ""
+}
+
+// Open opens the file for reading.
+func (f *FormFile) Open() (multipart.File, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return sectionReadCloser{io.NewSectionReader(bytes.NewReader(f.data), 0, int64(len(f.data)))}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error {
+	return nil
+}
+
+// ReadForm reads an application/x-www-form-urlencoded or a
+// multipart/form-data body, with the limits of opts. Other content types
+// fail with 415. The values and the files are kept in memory up to
+// MaxMemory, the files exceeding it are written to temporary files, to be
+// removed with Form.RemoveAll. The query parameters are not included.
+func (r *Request) ReadForm(opts FormOptions) (*Form, error) {
+	opts = opts.withDefaults()
+	form := &Form{Values: url.Values{}, Files: map[string][]*FormFile{}}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		body, err := r.limitBody(min(opts.MaxSize, opts.MaxMemory))
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, formError("", err)
+		}
+		if form.Values, err = url.ParseQuery(string(b)); err != nil {
+			return nil, formError("", err)
+		}
+		return form, nil
+	}
+
+	memory := opts.MaxMemory
+	for p, err := range r.Parts(opts) {
+		if err == nil {
+			err = form.readPart(p, &memory, opts)
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+	}
+	return form, nil
+}
+
+// readPart adds p to the form, decreasing the available memory
+func (f *Form) readPart(p *Part, memory *int64, opts FormOptions) error {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(p, *memory+1))
+	if err != nil {
+		return err
+	}
+	if !p.IsFile() {
+		if n > *memory {
+			return &FormError{http.StatusRequestEntityTooLarge, p.FormName, ErrFormTooLarge}
+		}
+		*memory -= n
+		f.Values.Add(p.FormName, buf.String())
+		return nil
+	}
+
+	file := &FormFile{FileName: p.FileName, ContentType: p.ContentType, Header: p.Header, Size: n}
+	if n <= *memory {
+		*memory -= n
+		file.data = buf.Bytes()
+	} else {
+		if opts.NoTempFiles {
+			return &FormError{http.StatusRequestEntityTooLarge, p.FormName, ErrFormTooLarge}
+		}
+		tmp, err := os.CreateTemp(opts.TempDir, "heligo-upload-*")
+		if err != nil {
+			return &FormError{http.StatusInternalServerError, p.FormName, err}
+		}
+		// registered before the copy, to be removed on errors
+		file.path = tmp.Name()
+		f.Files[p.FormName] = append(f.Files[p.FormName], file)
+		m, err := io.Copy(tmp, io.MultiReader(&buf, p))
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		file.Size = m
+		if _, ok := errors.AsType[*FormError](err); !ok && err != nil {
+			err = &FormError{http.StatusInternalServerError, p.FormName, err}
+		}
+		return err
+	}
+	f.Files[p.FormName] = append(f.Files[p.FormName], file)
+	return nil
+}
@@ -0,0 +1,230 @@
+package heligo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+// png returns a PNG signature followed by n zeros
+func png(n int) []byte {
+	return append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, n)...)
+}
+
+type formPart struct {
+	field, file string
+	content     []byte
+}
+
+func multipartRequest(t *testing.T, parts ...formPart) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.file != "" {
+			w, err = mw.CreateFormFile(p.field, p.file)
+		} else {
+			w, err = mw.CreateFormField(p.field)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(p.content)
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadForm(t *testing.T) {
+	tmp := t.TempDir()
+	var form *heligo.Form
+	router := heligo.New()
+	var gotStatus int
+	var gotErr error
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		gotStatus, gotErr = status, err
+		http.Error(w, err.Error(), status)
+	}
+	opts := heligo.FormOptions{
+		MaxSize:      1 << 20,
+		MaxFileSize:  1000,
+		MaxMemory:    300,
+		TempDir:      tmp,
+		AllowedTypes: []string{"image/*", "text/plain"},
+	}
+	router.Handle("POST", "/upload", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		var err error
+		form, err = r.ReadForm(opts)
+		if err != nil {
+			return heligo.FormStatus(err), err
+		}
+		return heligo.WriteHeader(w, http.StatusNoContent)
+	})
+
+	small := png(5)
+	large := png(500)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, multipartRequest(t,
+		formPart{"title", "", []byte("holidays")},
+		formPart{"photo", "a.png", small},
+		formPart{"photo", "b.png", large},
+		formPart{"notes", "notes.txt", []byte("some notes")},
+	))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if v := form.Value("title"); v != "holidays" {
+		t.Errorf("unexpected title %q", v)
+	}
+	photos := form.Files["photo"]
+	if len(photos) != 2 || photos[0].FileName != "a.png" || photos[0].ContentType != "image/png" {
+		t.Fatalf("unexpected photos %+v", photos)
+	}
+	if !photos[0].InMemory() || photos[1].InMemory() {
+		t.Errorf("expected the second photo to spill to a temporary file")
+	}
+	for i, want := range [][]byte{small, large} {
+		f, err := photos[i].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(f)
+		f.Close()
+		if !bytes.Equal(got, want) || photos[i].Size != int64(len(want)) {
+			t.Errorf("photo %d: got %d bytes, size %d", i, len(got), photos[i].Size)
+		}
+	}
+	if notes := form.File("notes"); notes == nil || !strings.HasPrefix(notes.ContentType, "text/plain") {
+		t.Errorf("unexpected notes %+v", notes)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 1 {
+		t.Errorf("expected a temporary file, got %d", len(entries))
+	}
+	if err := form.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("expected the temporary files to be removed, got %d", len(entries))
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		target error
+	}{
+		{"file too large", multipartRequest(t,
+			formPart{"photo", "a.png", small},
+			formPart{"photo", "b.png", png(2000)},
+		), http.StatusRequestEntityTooLarge, heligo.ErrFormTooLarge},
+		{"sniffed type", multipartRequest(t,
+			formPart{"photo", "fake.png", []byte("%PDF-1.7 not an image")},
+		), http.StatusUnsupportedMediaType, heligo.ErrUnsupportedType},
+		{"values exceeding memory", multipartRequest(t,
+			formPart{"text", "", bytes.Repeat([]byte{'a'}, 400)},
+		), http.StatusRequestEntityTooLarge, heligo.ErrFormTooLarge},
+		{"body too large", multipartRequest(t,
+			formPart{"a", "a.png", png(900)},
+			formPart{"b", "b.png", png(900)},
+		), http.StatusRequestEntityTooLarge, heligo.ErrFormTooLarge},
+		{"content type", httptest.NewRequest("POST", "/upload", strings.NewReader("{}")),
+			http.StatusUnsupportedMediaType, heligo.ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "body too large" {
+				opts.MaxSize = 1500
+				defer func() { opts.MaxSize = 1 << 20 }()
+				// without Content-Length, to exercise the streaming limit
+				tt.req.ContentLength = -1
+			}
+			gotErr = nil
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req)
+			if w.Code != tt.status || gotStatus != tt.status || !errors.Is(gotErr, tt.target) {
+				t.Errorf("expected %d, got %d with %v", tt.status, w.Code, gotErr)
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+				t.Errorf("expected no temporary files, got %d", len(entries))
+			}
+		})
+	}
+
+	t.Run("no temporary files", func(t *testing.T) {
+		opts.NoTempFiles = true
+		defer func() { opts.NoTempFiles = false }()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartRequest(t, formPart{"photo", "b.png", large}))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413, got %d", w.Code)
+		}
+	})
+
+	t.Run("urlencoded", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/upload?q=1", strings.NewReader("a=1&b=2&a=3"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent || strings.Join(form.Values["a"], ",") != "1,3" || form.Value("q") != "" {
+			t.Errorf("unexpected status %d or values %v", w.Code, form.Values)
+		}
+	})
+}
+
+func TestParts(t *testing.T) {
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		http.Error(w, err.Error(), status)
+	}
+	var got []string
+	router.Handle("POST", "/upload", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		for part, err := range r.Parts(heligo.FormOptions{MaxFileSize: 100, MaxParts: 3}) {
+			if err != nil {
+				return heligo.FormStatus(err), err
+			}
+			n, err := io.Copy(io.Discard, part)
+			if err != nil {
+				return heligo.FormStatus(err), err
+			}
+			got = append(got, part.FormName+":"+part.FileName+":"+part.ContentType+":"+strconv.FormatInt(n, 10))
+			if part.FormName == "stop" {
+				break
+			}
+		}
+		return heligo.WriteHeader(w, http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, multipartRequest(t,
+		formPart{"name", "", []byte("abc")},
+		formPart{"doc", "doc.txt", []byte("hello")},
+		formPart{"stop", "", nil},
+		formPart{"ignored", "", nil},
+	))
+	want := []string{"name:::3", "doc:doc.txt:text/plain; charset=utf-8:5", "stop:::0"}
+	if w.Code != http.StatusNoContent || strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("unexpected status %d or parts %q", w.Code, got)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, multipartRequest(t,
+		formPart{"a", "", nil}, formPart{"b", "", nil}, formPart{"c", "", nil}, formPart{"d", "", nil},
+	))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many parts: expected 413, got %d", w.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package heligo
+
+import "strings"
+
+const DOT = '.'
+
+// parseHost returns the interned parameter names of a host pattern
+func parseHost(pattern string) []uint16 {
+	var names []uint16
+	for label := range strings.SplitSeq(pattern, ".") {
+		if len(label) > 0 && label[0] == COLON {
+			names = append(names, internName(label[1:]))
+		}
+	}
+	return names
+}
+
+// stripPort removes the port and the trailing dot from a request host
+func stripPort(host string) string {
+	for i := len(host) - 1; i >= 0; i-- {
+		if host[i] == ']' {
+			break
+		}
+		if host[i] == COLON {
+			host = host[:i]
+			break
+		}
+	}
+	if len(host) > 0 && host[len(host)-1] == DOT {
+		host = host[:len(host)-1]
+	}
+	return host
+}
+
+// matchHost matches host against the pattern of rs, label by label,
+// storing the parameters in p as offsets into host.
+func matchHost(rs *routes, host string, p *params) bool {
+	host = stripPort(host)
+	pattern := rs.host
+	i, j, k := 0, 0, 0
+	for i < len(pattern) && j < len(host) {
+		pe := i
+		for pe < len(pattern) && pattern[pe] != DOT {
+			pe++
+		}
+		he := j
+		for he < len(host) && host[he] != DOT {
+			he++
+		}
+		if pattern[i] == COLON {
+			if he == j {
+				return false
+			}
+			p.names[k] = rs.hostParams[k]
+			p.valueBeg[k] = uint32(j)
+			p.valueEnd[k] = uint32(he)
+			k++
+		} else if !strings.EqualFold(pattern[i:pe], host[j:he]) {
+			return false
+		}
+		i, j = pe+1, he+1
+	}
+	if i < len(pattern) || j < len(host) {
+		return false
+	}
+	p.count = uint8(k)
+	p.hostCount = uint8(k)
+	return true
+}
@@ -0,0 +1,357 @@
+package heligo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyMissing is returned with 400 when the key is required
+	// and missing, or too long
+	ErrIdempotencyKeyMissing = errors.New("missing or invalid idempotency key")
+	// ErrIdempotencyInProgress is returned with 409 for the retries of a
+	// request still being processed
+	ErrIdempotencyInProgress = errors.New("request with the same idempotency key in progress")
+	// ErrIdempotencyMismatch is returned with 422 when a key is reused
+	// with a different request
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyNotReplayable is returned with 409 for the retries of a
+	// request completed without a response to replay
+	ErrIdempotencyNotReplayable = errors.New("request with the same idempotency key completed, response not replayable")
+	// ErrIdempotencyStoreFull is returned with 503 when the store can't
+	// keep more keys in progress
+	ErrIdempotencyStoreFull = errors.New("too many idempotency keys in progress")
+)
+
+// maxIdempotencyKey is the maximum length of the keys
+const maxIdempotencyKey = 255
+
+// IdempotentResponse is a response recorded by Idempotency.
+// A zero Status marks a request that ran without a response to replay.
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyRecord is the state of a key in an IdempotencyStore.
+type IdempotencyRecord struct {
+	// RequestHash identifies the request that used the key first
+	RequestHash string
+	// Done reports whether the request is complete, with its Response
+	Done     bool
+	Response IdempotentResponse
+}
+
+// IdempotencyStore keeps the idempotency keys and the recorded responses.
+// It must be safe for concurrent use, and Begin atomic.
+type IdempotencyStore interface {
+	// Begin records a new key in progress for the request hash, returning
+	// true, or returns the existing record and false
+	Begin(ctx context.Context, key string, requestHash string, ttl time.Duration) (IdempotencyRecord, bool, error)
+	// Complete records the response of a key in progress, with a zero
+	// Status if it can't be replayed
+	Complete(ctx context.Context, key string, resp IdempotentResponse, ttl time.Duration) error
+	// Abandon removes a key in progress, so that the request can be retried
+	Abandon(ctx context.Context, key string) error
+}
+
+// IdempotencyOptions configures the Idempotency middleware.
+type IdempotencyOptions struct {
+	// Store keeps the keys, a MemoryIdempotencyStore of 10000 keys if nil
+	Store IdempotencyStore
+	// TTL is the time the keys are kept, 24 hours if zero
+	TTL time.Duration
+	// Header is the header of the key, "Idempotency-Key" if empty
+	Header string
+	// Required rejects the requests without key with 400
+	Required bool
+	// MaxBodySize limits the requests, hashed to detect the reuse of the
+	// keys, and the responses recorded, 1MB if zero. Larger requests are
+	// rejected with 413, larger responses are not recorded.
+	MaxBodySize int64
+}
+
+// Idempotency returns a middleware making the retries of unsafe requests
+// safe, as described in the IETF Idempotency-Key header draft.
+//
+// The first response to a request with a key is recorded and replayed to
+// its retries, with the Idempotent-Replayed header. Keys are scoped by
+// method, route and authenticated principal, see PrincipalFromContext,
+// so the middleware should follow the authentication. The retries of a
+// request in progress are rejected with 409 and the reuse of a key with
+// a different request with 422, returning the error to the ErrorHandler.
+// A status returned without writing is written, so that the recorded
+// response is the one sent.
+//
+// Only the requests rejected with a 4xx error, before writing, release
+// their key to be retried. The other errors, the 5xx and 1xx statuses,
+// the responses larger than MaxBodySize and the panics leave a key that
+// can't be replayed, as the request may have had effects: its retries are
+// rejected with 409 and ErrIdempotencyNotReplayable.
+func Idempotency(opts IdempotencyOptions) Middleware {
+	if opts.Store == nil {
+		opts.Store = NewMemoryIdempotencyStore(0)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.Header == "" {
+		opts.Header = "Idempotency-Key"
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				return next(ctx, w, r)
+			}
+			key := r.Header.Get(opts.Header)
+			if key == "" && !opts.Required {
+				return next(ctx, w, r)
+			}
+			if key == "" || len(key) > maxIdempotencyKey {
+				return http.StatusBadRequest, ErrIdempotencyKeyMissing
+			}
+
+			hash, err := requestHash(r.Request, opts.MaxBodySize)
+			if err != nil {
+				if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+					return http.StatusRequestEntityTooLarge, err
+				}
+				return http.StatusBadRequest, err
+			}
+			rt := r.Route()
+			scope := r.Method + " " + rt.Host + rt.Pattern + "\n"
+			if p, ok := PrincipalFromContext(ctx); ok {
+				scope += p.Scheme + " " + p.Subject + "\n"
+			}
+			key = scope + key
+
+			rec, ok, err := opts.Store.Begin(ctx, key, hash, opts.TTL)
+			if errors.Is(err, ErrIdempotencyStoreFull) {
+				return http.StatusServiceUnavailable, err
+			} else if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			if !ok {
+				switch {
+				case rec.RequestHash != hash:
+					return http.StatusUnprocessableEntity, ErrIdempotencyMismatch
+				case !rec.Done:
+					return http.StatusConflict, ErrIdempotencyInProgress
+				case rec.Response.Status == 0:
+					return http.StatusConflict, ErrIdempotencyNotReplayable
+				}
+				return replay(w, rec.Response)
+			}
+
+			// a panic leaves the key without response
+			completed := false
+			defer func() {
+				if !completed {
+					opts.Store.Complete(context.WithoutCancel(ctx), key, IdempotentResponse{}, opts.TTL)
+				}
+			}()
+			rw := newRecordingWriter(w, opts.MaxBodySize)
+			status, err := next(ctx, rw, r)
+			if err != nil && !rw.Written() && status >= 400 && status < 500 {
+				// rejected: the request can be retried
+				completed = true
+				opts.Store.Abandon(context.WithoutCancel(ctx), key)
+				return status, err
+			}
+			if err == nil && !rw.Written() {
+				if status < 200 || status > 999 {
+					status = http.StatusOK
+				}
+				rw.WriteHeader(status)
+			}
+			var resp IdempotentResponse
+			if err == nil && rw.recorded() && rw.Status() >= 200 && rw.Status() < 500 {
+				resp = IdempotentResponse{Status: rw.Status(), Header: rw.header, Body: rw.body.buf.Bytes()}
+			}
+			completed = true
+			if cerr := opts.Store.Complete(context.WithoutCancel(ctx), key, resp, opts.TTL); cerr != nil && err == nil {
+				return status, cerr
+			}
+			return status, err
+		}
+	}
+}
+
+// requestHash hashes the method, the URL and the body, restoring it
+func requestHash(r *http.Request, max int64) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, max))
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func replay(w http.ResponseWriter, resp IdempotentResponse) (int, error) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = v
+	}
+	h.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	_, err := w.Write(resp.Body)
+	return resp.Status, err
+}
+
+// recordingWriter records the response while writing it
+type recordingWriter struct {
+	*ResponseWriter
+	header http.Header // the header written, nil if none
+	body   cappedBuffer
+}
+
+func newRecordingWriter(w http.ResponseWriter, max int64) *recordingWriter {
+	return &recordingWriter{ResponseWriter: NewResponseWriter(w), body: cappedBuffer{max: max}}
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if !w.Written() && (code < 100 || code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom records the body while copying it.
+func (w *recordingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.ReadFrom(io.TeeReader(r, &w.body))
+}
+
+// recorded reports whether the response written can be replayed:
+// not hijacked and not larger than max
+func (w *recordingWriter) recorded() bool {
+	return w.header != nil && !w.body.overflow
+}
+
+// cappedBuffer buffers up to max bytes, dropping them all beyond
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow {
+		if int64(b.buf.Len()+len(p)) > b.max {
+			b.overflow = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore, evicting the
+// least recently used completed keys. The keys in progress are never
+// evicted: when they reach the maximum, Begin fails with
+// ErrIdempotencyStoreFull.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	maxKeys int
+	pending map[string]pendingKey
+	records *lru[string, IdempotencyRecord]
+}
+
+// pendingKey is a key in progress
+type pendingKey struct {
+	requestHash string
+	expires     time.Time
+}
+
+// NewMemoryIdempotencyStore creates a store of at most maxKeys completed
+// keys and maxKeys keys in progress, 10000 if zero.
+func NewMemoryIdempotencyStore(maxKeys int) *MemoryIdempotencyStore {
+	if maxKeys <= 0 {
+		maxKeys = 10000
+	}
+	return &MemoryIdempotencyStore{
+		maxKeys: maxKeys,
+		pending: map[string]pendingKey{},
+		records: newLRU[string, IdempotencyRecord](int64(maxKeys)),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key string, requestHash string, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if p, ok := s.pending[key]; ok && now.Before(p.expires) {
+		return IdempotencyRecord{RequestHash: p.requestHash}, false, nil
+	}
+	if rec, ok := s.records.get(key, now); ok {
+		return rec, false, nil
+	}
+	if len(s.pending) >= s.maxKeys {
+		for k, p := range s.pending {
+			if !now.Before(p.expires) {
+				delete(s.pending, k)
+			}
+		}
+		if len(s.pending) >= s.maxKeys {
+			return IdempotencyRecord{}, false, ErrIdempotencyStoreFull
+		}
+	}
+	s.pending[key] = pendingKey{requestHash, now.Add(ttl)}
+	return IdempotencyRecord{}, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, resp IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[key]
+	if !ok {
+		return errors.New("heligo: idempotency key not found")
+	}
+	delete(s.pending, key)
+	rec := IdempotencyRecord{RequestHash: p.requestHash, Done: true, Response: resp}
+	s.records.add(key, rec, 1, time.Now().Add(ttl))
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Abandon(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, key)
+	return nil
+}
+
+// Len returns the number of keys, completed or in progress.
+func (s *MemoryIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) + s.records.len()
+}
@@ -0,0 +1,217 @@
+package heligo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestIdempotency(t *testing.T) {
+	router := heligo.New()
+	var gotErr error
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		gotErr = err
+		http.Error(w, err.Error(), status)
+	}
+	// the principal from a test header
+	router.Use(func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			if user := r.Header.Get("X-User"); user != "" {
+				ctx = heligo.WithPrincipal(ctx, heligo.Principal{Subject: user, Scheme: "Test"})
+			}
+			return next(ctx, w, r)
+		}
+	})
+	router.Use(heligo.Recover(nil))
+	router.Use(heligo.Idempotency(heligo.IdempotencyOptions{}))
+
+	calls := 0
+	release := make(chan struct{})
+	started := make(chan struct{})
+	router.Handle("POST", "/payments", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case "slow":
+			close(started)
+			<-release
+		case "fail":
+			return http.StatusBadGateway, errors.New("upstream failed")
+		case "reject":
+			return http.StatusBadRequest, errors.New("invalid payment")
+		case "silent":
+			return http.StatusAccepted, nil
+		case "large":
+			w.Write([]byte(strings.Repeat("x", 1<<20+1)))
+			return http.StatusOK, nil
+		case "panic":
+			panic("boom")
+		}
+		w.Header().Set("Location", fmt.Sprintf("/payments/%d", calls))
+		return heligo.WriteJSON(w, http.StatusCreated, map[string]int{"id": calls})
+	})
+
+	post := func(key string, user string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/payments", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("k1", "alice", "10 EUR")
+	if w.Code != 201 || w.Body.String() != `{"id":1}` || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("unexpected first response %d %q", w.Code, w.Body)
+	}
+	w = post("k1", "alice", "10 EUR")
+	if w.Code != 201 || w.Body.String() != `{"id":1}` || w.Header().Get("Location") != "/payments/1" ||
+		w.Header().Get("Idempotent-Replayed") != "true" || calls != 1 {
+		t.Errorf("expected the replay of the first response, got %d %q after %d calls", w.Code, w.Body, calls)
+	}
+
+	if w = post("k1", "alice", "20 EUR"); w.Code != 422 || !errors.Is(gotErr, heligo.ErrIdempotencyMismatch) {
+		t.Errorf("expected 422 for a different body, got %d", w.Code)
+	}
+	if w = post("k1", "bob", "10 EUR"); w.Code != 201 || calls != 2 {
+		t.Errorf("expected keys scoped by principal, got %d after %d calls", w.Code, calls)
+	}
+	if w = post("", "", "10 EUR"); w.Code != 201 || calls != 3 {
+		t.Errorf("expected requests without key to pass, got %d", w.Code)
+	}
+
+	// the client errors release the key
+	calls = 0
+	post("k2", "", "reject")
+	if w = post("k2", "", "reject"); w.Code != 400 || calls != 2 {
+		t.Errorf("expected the rejected request to be executed again, got %d after %d calls", w.Code, calls)
+	}
+	// the other failures are not replayable, and not executed again
+	for _, body := range []string{"fail", "large", "panic"} {
+		calls = 0
+		post("k-"+body, "", body)
+		w = post("k-"+body, "", body)
+		if w.Code != 409 || !errors.Is(gotErr, heligo.ErrIdempotencyNotReplayable) || calls != 1 {
+			t.Errorf("%s: expected 409 without executing again, got %d after %d calls", body, w.Code, calls)
+		}
+	}
+	// a status returned without writing is sent and recorded
+	if w = post("k4", "", "silent"); w.Code != 202 {
+		t.Errorf("expected the returned status to be sent, got %d", w.Code)
+	}
+	if w = post("k4", "", "silent"); w.Code != 202 || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the replay of the sent status, got %d", w.Code)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("k3", "", "slow") }()
+	<-started
+	if w = post("k3", "", "slow"); w.Code != 409 || !errors.Is(gotErr, heligo.ErrIdempotencyInProgress) {
+		t.Errorf("expected 409 for a request in progress, got %d", w.Code)
+	}
+	close(release)
+	if w = <-done; w.Code != 201 {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+	if w = post("k3", "", "slow"); w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the replay after completion, got %d", w.Code)
+	}
+
+	if w = post(strings.Repeat("k", 300), "", ""); w.Code != 400 || !errors.Is(gotErr, heligo.ErrIdempotencyKeyMissing) {
+		t.Errorf("expected 400 for a long key, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyOptions(t *testing.T) {
+	store := heligo.NewMemoryIdempotencyStore(2)
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		http.Error(w, err.Error(), status)
+	}
+	router.Use(heligo.Idempotency(heligo.IdempotencyOptions{
+		Store:       store,
+		TTL:         50 * time.Millisecond,
+		Header:      "X-Request-Id",
+		Required:    true,
+		MaxBodySize: 10,
+	}))
+	calls := 0
+	router.Handle("PUT", "/items/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		calls++
+		return heligo.WriteHeader(w, http.StatusNoContent)
+	})
+	put := func(key string, body string) int {
+		req := httptest.NewRequest("PUT", "/items/1", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-Request-Id", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := put("", "x"); status != 400 {
+		t.Errorf("expected 400 for a missing key, got %d", status)
+	}
+	if status := put("a", strings.Repeat("x", 11)); status != 413 {
+		t.Errorf("expected 413 for a large body, got %d", status)
+	}
+	put("a", "x")
+	put("a", "x")
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	put("b", "x")
+	put("c", "x")
+	if n := store.Len(); n != 2 {
+		t.Errorf("expected 2 keys, got %d", n)
+	}
+	put("a", "x")
+	if calls != 4 {
+		t.Errorf("expected the evicted key to be executed again, got %d calls", calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	put("a", "x")
+	if calls != 5 {
+		t.Errorf("expected the expired key to be executed again, got %d calls", calls)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := heligo.NewMemoryIdempotencyStore(1)
+	if _, ok, err := store.Begin(ctx, "a", "h", time.Minute); !ok || err != nil {
+		t.Fatalf("expected a new key, got %v %v", ok, err)
+	}
+	// the key in progress is not evicted
+	if _, _, err := store.Begin(ctx, "b", "h", time.Minute); !errors.Is(err, heligo.ErrIdempotencyStoreFull) {
+		t.Errorf("expected ErrIdempotencyStoreFull, got %v", err)
+	}
+	if rec, ok, _ := store.Begin(ctx, "a", "h", time.Minute); ok || rec.Done || rec.RequestHash != "h" {
+		t.Errorf("expected the key in progress, got %v %+v", ok, rec)
+	}
+	store.Complete(ctx, "a", heligo.IdempotentResponse{Status: 200}, time.Minute)
+	if _, ok, err := store.Begin(ctx, "b", "h", time.Minute); !ok || err != nil {
+		t.Errorf("expected a new key, got %v %v", ok, err)
+	}
+	if n := store.Len(); n != 2 {
+		t.Errorf("expected 2 keys, got %d", n)
+	}
+	// completed keys are evicted
+	store.Complete(ctx, "b", heligo.IdempotentResponse{Status: 200}, time.Minute)
+	if _, ok, _ := store.Begin(ctx, "a", "h", time.Minute); !ok {
+		t.Error("expected the completed key to be evicted")
+	}
+}
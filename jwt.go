@@ -0,0 +1,494 @@
+package heligo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT errors, all wrapped in ErrUnauthorized by the JWT middleware
+var (
+	ErrTokenMalformed = errors.New("jwt: malformed token")
+	ErrTokenAlgorithm = errors.New("jwt: unsupported algorithm")
+	ErrTokenSignature = errors.New("jwt: invalid signature")
+	ErrTokenExpired   = errors.New("jwt: token expired")
+	ErrTokenNotYet    = errors.New("jwt: token not valid yet")
+	ErrTokenIssuer    = errors.New("jwt: invalid issuer")
+	ErrTokenAudience  = errors.New("jwt: invalid audience")
+	ErrKeyNotFound    = errors.New("jwt: key not found")
+)
+
+// NumericDate is a JWT date, expressed in seconds since the Unix epoch.
+type NumericDate int64
+
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return err
+	}
+	*d = NumericDate(f)
+	return nil
+}
+
+// Time converts the date to a time.Time
+func (d NumericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+// Audience is the "aud" claim, that can be a single string or an array.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Claims holds the registered claims of a verified token.
+// Custom claims can be extracted with Decode.
+type Claims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  Audience     `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+	payload   []byte
+}
+
+// Decode decodes the full token payload into the value pointed by v.
+func (c *Claims) Decode(v any) error {
+	return json.Unmarshal(c.payload, v)
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext gets the claims of the verified token from the context
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// KeySet provides the verification keys for tokens.
+// Keys are []byte for HS256, *rsa.PublicKey for RS256,
+// *ecdsa.PublicKey for ES256 and ed25519.PublicKey for EdDSA.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (any, error)
+}
+
+// KeyFunc is an adapter to use an ordinary function as a KeySet.
+type KeyFunc func(ctx context.Context, kid string) (any, error)
+
+func (f KeyFunc) Key(ctx context.Context, kid string) (any, error) {
+	return f(ctx, kid)
+}
+
+// StaticKey returns a KeySet that always returns key.
+func StaticKey(key any) KeySet {
+	return KeyFunc(func(context.Context, string) (any, error) {
+		return key, nil
+	})
+}
+
+// JWTOptions configures the JWT middleware.
+type JWTOptions struct {
+	// Keys provides the verification keys (required)
+	Keys KeySet
+	// Algorithms restricts the accepted algorithms; all the supported
+	// ones (HS256, RS256, ES256, EdDSA) if empty
+	Algorithms []string
+	// Issuer, if not empty, must match the "iss" claim
+	Issuer string
+	// Audience, if not empty, must be contained in the "aud" claim
+	Audience string
+	// Leeway is the clock skew tolerated checking "exp" and "nbf"
+	Leeway time.Duration
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// JWT returns a middleware that verifies the bearer token of the request.
+// On success the claims are available with ClaimsFromContext and the
+// principal with PrincipalFromContext. Failures are returned as 401 with
+// a Bearer challenge.
+func JWT(opts JWTOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			token, ok := bearerToken(r.Request)
+			if !ok {
+				return unauthorized(w, "Bearer", nil)
+			}
+			claims, err := opts.Verify(ctx, token)
+			if err != nil {
+				return unauthorized(w, `Bearer error="invalid_token"`, err)
+			}
+			ctx = context.WithValue(ctx, claimsKey{}, claims)
+			ctx = WithPrincipal(ctx, Principal{Subject: claims.Subject, Scheme: "Bearer"})
+			return next(ctx, w, r)
+		}
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify parses and verifies a compact serialized token, returning its claims.
+func (opts *JWTOptions) Verify(ctx context.Context, token string) (*Claims, error) {
+	h64, rest, ok1 := strings.Cut(token, ".")
+	p64, s64, ok2 := strings.Cut(rest, ".")
+	if !ok1 || !ok2 {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := decodeSegment(h64, &header); err != nil {
+		return nil, err
+	}
+	if len(opts.Algorithms) > 0 && !slices.Contains(opts.Algorithms, header.Alg) {
+		return nil, ErrTokenAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s64)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if opts.Keys == nil {
+		return nil, ErrKeyNotFound
+	}
+	key, err := opts.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, token[:len(h64)+1+len(p64)], sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(p64)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := &Claims{payload: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	t := now()
+	if claims.ExpiresAt != nil && !t.Before(claims.ExpiresAt.Time().Add(opts.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != nil && t.Add(opts.Leeway).Before(claims.NotBefore.Time()) {
+		return nil, ErrTokenNotYet
+	}
+	if opts.Issuer != "" && claims.Issuer != opts.Issuer {
+		return nil, ErrTokenIssuer
+	}
+	if opts.Audience != "" && !slices.Contains(claims.Audience, opts.Audience) {
+		return nil, ErrTokenAudience
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+// verifySignature checks the signature, requiring a key type consistent with
+// the algorithm to prevent algorithm confusion.
+func verifySignature(alg string, key any, signed string, sig []byte) error {
+	switch alg {
+	case "HS256":
+		k, ok := key.([]byte)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		mac := hmac.New(sha256.New, k)
+		io.WriteString(mac, signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrTokenSignature
+		}
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) != nil {
+			return ErrTokenSignature
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			return ErrTokenAlgorithm
+		}
+		if len(sig) != 64 {
+			return ErrTokenSignature
+		}
+		sum := sha256.Sum256([]byte(signed))
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return ErrTokenSignature
+		}
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if !ed25519.Verify(k, []byte(signed), sig) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrTokenAlgorithm
+	}
+	return nil
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517).
+// A key without "kid" matches any token without "kid".
+type JWKS struct {
+	keys map[string]any
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set.
+// RSA, EC P-256, OKP Ed25519 and oct keys are supported; others are skipped.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	jwks := &JWKS{keys: make(map[string]any, len(set.Keys))}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			jwks.keys[k.Kid] = key
+		}
+	}
+	return jwks, nil
+}
+
+// LoadJWKS reads a JSON Web Key Set from a file.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// FetchJWKS downloads a JSON Web Key Set using client, or
+// http.DefaultClient if nil.
+func FetchJWKS(ctx context.Context, client *http.Client, url string) (*JWKS, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: fetching %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func (s *JWKS) Key(ctx context.Context, kid string) (any, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (k *jwk) publicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err1 := dec(k.N)
+		e, err2 := dec(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			return nil, ErrTokenMalformed
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err1 := dec(k.X)
+		y, err2 := dec(k.Y)
+		if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, ErrTokenMalformed
+		}
+		// validate the point through the uncompressed SEC 1 encoding
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := dec(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrTokenMalformed
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		b, err := dec(k.K)
+		if err != nil {
+			return nil, ErrTokenMalformed
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+// RemoteJWKS is a KeySet fetched from a URL and cached.
+// The set is refreshed when it is older than TTL or when an unknown key id
+// is requested, but not more often than once every MinRefresh, failed
+// attempts included. Concurrent lookups wait for a single fetch, and on
+// failures the previous set is still used.
+type RemoteJWKS struct {
+	URL    string
+	Client *http.Client
+	TTL    time.Duration
+	// MinRefresh is the minimum interval between the fetches, 1 minute if zero
+	MinRefresh time.Duration
+
+	mu        sync.Mutex
+	set       *JWKS
+	fetched   time.Time // of the current set
+	attempted time.Time // of the last fetch
+	err       error     // of the last fetch
+	fetch     *jwksFetch
+}
+
+// jwksFetch is a fetch of a RemoteJWKS in progress
+type jwksFetch struct {
+	done chan struct{}
+}
+
+// jwksFetchTimeout limits the fetches, which don't depend on the
+// context of the request starting them
+const jwksFetchTimeout = 30 * time.Second
+
+func (s *RemoteJWKS) Key(ctx context.Context, kid string) (any, error) {
+	minRefresh := s.MinRefresh
+	if minRefresh <= 0 {
+		minRefresh = time.Minute
+	}
+	s.mu.Lock()
+	now := time.Now()
+	if s.set != nil && (s.TTL <= 0 || now.Sub(s.fetched) < s.TTL) {
+		if key, err := s.set.Key(ctx, kid); err == nil {
+			s.mu.Unlock()
+			return key, nil
+		}
+	}
+	f := s.fetch
+	switch {
+	case f != nil:
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	case now.Sub(s.attempted) < minRefresh:
+		s.mu.Unlock()
+	default:
+		f = &jwksFetch{done: make(chan struct{})}
+		s.fetch, s.attempted = f, now
+		s.mu.Unlock()
+
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+		set, err := FetchJWKS(fctx, s.Client, s.URL)
+		cancel()
+		s.mu.Lock()
+		if err == nil {
+			s.set, s.fetched = set, time.Now()
+		}
+		s.err, s.fetch = err, nil
+		s.mu.Unlock()
+		close(f.done)
+	}
+
+	s.mu.Lock()
+	set, err := s.set, s.err
+	s.mu.Unlock()
+	if set == nil {
+		return nil, err
+	}
+	// a stale set is still used
+	return set.Key(ctx, kid)
+}
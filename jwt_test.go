@@ -0,0 +1,284 @@
+package heligo_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+var b64 = base64.RawURLEncoding
+
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, sum[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func testKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey, []byte) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, ecKey, edKey, []byte("hs-secret")
+}
+
+func testJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, edKey ed25519.PrivateKey, hsKey []byte) []byte {
+	ecBytes, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64.EncodeToString(ecBytes[1:33]), "y": b64.EncodeToString(ecBytes[33:])},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(edKey.Public().(ed25519.PublicKey))},
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(hsKey)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func newJWTRouter(opts heligo.JWTOptions) *heligo.Router {
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		http.Error(w, err.Error(), status)
+	}
+	router.Use(heligo.JWT(opts))
+	router.Handle("GET", "/claims", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		claims, _ := heligo.ClaimsFromContext(ctx)
+		var custom struct {
+			Role string `json:"role"`
+		}
+		if err := claims.Decode(&custom); err != nil {
+			return 500, err
+		}
+		w.Write([]byte(claims.Subject + ":" + custom.Role))
+		return 200, nil
+	})
+	return router
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, ecKey, edKey, hsKey := testKeys(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, testJWKS(t, rsaKey, ecKey, edKey, hsKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := heligo.LoadJWKS(jwksFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	router := newJWTRouter(heligo.JWTOptions{
+		Keys:     jwks,
+		Issuer:   "https://issuer",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+		Now:      func() time.Time { return now },
+	})
+
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "role": "admin", "iss": "https://issuer", "aud": []string{"web", "api"}, "exp": now.Unix() + 60}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"HS256", signJWT(t, "HS256", "hs", hsKey, claims(nil)), 200},
+		{"RS256", signJWT(t, "RS256", "rsa", rsaKey, claims(nil)), 200},
+		{"ES256", signJWT(t, "ES256", "ec", ecKey, claims(nil)), 200},
+		{"EdDSA", signJWT(t, "EdDSA", "ed", edKey, claims(nil)), 200},
+		{"aud string", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"aud": "api"})), 200},
+		{"exp in leeway", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"exp": now.Unix() - 10})), 200},
+		{"expired", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"exp": now.Unix() - 31})), 401},
+		{"nbf in leeway", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"nbf": now.Unix() + 10})), 200},
+		{"not yet", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"nbf": now.Unix() + 60})), 401},
+		{"issuer", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"iss": "other"})), 401},
+		{"audience", signJWT(t, "HS256", "hs", hsKey, claims(map[string]any{"aud": "web"})), 401},
+		{"unknown kid", signJWT(t, "HS256", "nope", hsKey, claims(nil)), 401},
+		{"wrong key", signJWT(t, "HS256", "hs", []byte("other"), claims(nil)), 401},
+		{"alg confusion", signJWT(t, "HS256", "rsa", hsKey, claims(nil)), 401},
+		{"alg none", signJWT(t, "none", "hs", hsKey, claims(nil)), 401},
+		{"malformed", "abc.def", 401},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/claims", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d (%s)", test.name, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code == 200 && w.Body.String() != "alice:admin" {
+			t.Errorf("%s: wrong claims %q", test.name, w.Body.String())
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+			t.Errorf("%s: wrong challenge %q", test.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestJWTRemoteJWKS(t *testing.T) {
+	rsaKey, ecKey, edKey, hsKey := testKeys(t)
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(testJWKS(t, rsaKey, ecKey, edKey, hsKey))
+	}))
+	defer ts.Close()
+
+	router := newJWTRouter(heligo.JWTOptions{
+		Keys:       &heligo.RemoteJWKS{URL: ts.URL, Client: ts.Client(), TTL: time.Hour, MinRefresh: time.Hour},
+		Algorithms: []string{"RS256"},
+	})
+	for _, test := range []struct {
+		token  string
+		status int
+	}{
+		{signJWT(t, "RS256", "rsa", rsaKey, map[string]any{"sub": "bob"}), 200},
+		{signJWT(t, "RS256", "rsa", rsaKey, map[string]any{"sub": "bob"}), 200},
+		{signJWT(t, "ES256", "ec", ecKey, map[string]any{"sub": "bob"}), 401},
+		{signJWT(t, "RS256", "missing", rsaKey, map[string]any{"sub": "bob"}), 401},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/claims", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("expected %d, got %d (%s)", test.status, w.Code, w.Body.String())
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/claims", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("missing token: expected 401 Bearer, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestRemoteJWKSRefresh(t *testing.T) {
+	var fetches atomic.Int32
+	var fail atomic.Bool
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"keys": [{"kty": "oct", "kid": "hs", "k": "c2VjcmV0"}]}`))
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	// concurrent lookups wait for a single fetch
+	keys := &heligo.RemoteJWKS{URL: ts.URL, Client: ts.Client()}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := keys.Key(ctx, "hs"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// unknown key ids don't refresh more than once a minute by default
+	for range 3 {
+		if _, err := keys.Key(ctx, "missing"); !errors.Is(err, heligo.ErrKeyNotFound) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected 1 fetch, got %d", n)
+	}
+
+	// the failed fetches are limited too
+	fail.Store(true)
+	fetches.Store(0)
+	failing := &heligo.RemoteJWKS{URL: ts.URL, Client: ts.Client()}
+	for range 3 {
+		if _, err := failing.Key(ctx, "hs"); err == nil {
+			t.Error("expected an error")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected 1 failed fetch, got %d", n)
+	}
+
+	// the stale set is still used when the refresh fails
+	fail.Store(false)
+	stale := &heligo.RemoteJWKS{URL: ts.URL, Client: ts.Client(), TTL: time.Millisecond, MinRefresh: time.Millisecond}
+	if _, err := stale.Key(ctx, "hs"); err != nil {
+		t.Fatal(err)
+	}
+	fail.Store(true)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := stale.Key(ctx, "hs"); err != nil {
+		t.Errorf("expected the stale key, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package heligo
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is a least recently used cache with expiration, bounded by the total
+// cost of its entries. It is not safe for concurrent use.
+type lru[K comparable, V any] struct {
+	maxCost int64
+	cost    int64
+	ll      list.List
+	items   map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	cost    int64
+	expires time.Time // never if zero
+}
+
+func newLRU[K comparable, V any](maxCost int64) *lru[K, V] {
+	return &lru[K, V]{maxCost: maxCost, items: map[K]*list.Element{}}
+}
+
+// get returns the value of key, removing it if expired at now
+func (c *lru[K, V]) get(key K, now time.Time) (V, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[K, V])
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		c.removeElement(el)
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// add adds or replaces the value of key, evicting the least recently used
+// entries exceeding the maximum cost. Entries costlier than it are not added.
+func (c *lru[K, V]) add(key K, value V, cost int64, expires time.Time) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	if cost > c.maxCost {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key, value, cost, expires})
+	c.cost += cost
+	for c.cost > c.maxCost {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lru[K, V]) remove(key K) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lru[K, V]) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*lruEntry[K, V])
+	delete(c.items, e.key)
+	c.cost -= e.cost
+}
+
+func (c *lru[K, V]) len() int {
+	return c.ll.Len()
+}
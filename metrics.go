@@ -0,0 +1,229 @@
+package heligo
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the default latency buckets, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsOptions configures a MetricsCollector.
+type MetricsOptions struct {
+	// Namespace prefixes the metric names, as in "myapp_http_requests_total"
+	Namespace string
+	// Buckets are the upper bounds of the latency histogram, in seconds,
+	// DefaultBuckets if empty
+	Buckets []float64
+}
+
+// MetricsCollector collects the request metrics recorded by the Metrics
+// middleware, exposing them in the Prometheus text format:
+//
+//	http_requests_total{method, route, status}                counter
+//	http_request_duration_seconds{method, route, status}      histogram
+//	http_requests_in_flight{method, route}                    gauge
+//
+// The route label is the pattern of the matched route, not the request
+// path, to bound the cardinality, prefixed by the host pattern for the
+// routes of host groups. The status is the one returned by the
+// handler.
+type MetricsCollector struct {
+	prefix   string
+	buckets  []float64
+	mu       sync.RWMutex
+	requests map[seriesKey]*histogram
+	inFlight map[seriesKey]*atomic.Int64
+}
+
+type seriesKey struct {
+	method string
+	route  string
+	status int
+}
+
+// histogram counts the observations by bucket
+type histogram struct {
+	counts []atomic.Uint64 // one per bucket, not cumulative, plus +Inf
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	i, _ := slices.BinarySearch(buckets, v)
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// NewMetricsCollector creates a collector.
+func NewMetricsCollector(opts MetricsOptions) *MetricsCollector {
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	prefix := ""
+	if opts.Namespace != "" {
+		prefix = opts.Namespace + "_"
+	}
+	return &MetricsCollector{
+		prefix:   prefix,
+		buckets:  buckets,
+		requests: map[seriesKey]*histogram{},
+		inFlight: map[seriesKey]*atomic.Int64{},
+	}
+}
+
+// Metrics returns a middleware recording the request metrics in c.
+func Metrics(c *MetricsCollector) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+			rt := r.Route()
+			route := rt.Host + rt.Pattern
+			gauge := c.gauge(seriesKey{method: r.Method, route: route})
+			gauge.Add(1)
+			defer gauge.Add(-1)
+			start := time.Now()
+			status, err := next(ctx, w, r)
+			c.histogram(seriesKey{r.Method, route, status}).observe(c.buckets, time.Since(start).Seconds())
+			return status, err
+		}
+	}
+}
+
+func (c *MetricsCollector) gauge(key seriesKey) *atomic.Int64 {
+	c.mu.RLock()
+	g := c.inFlight[key]
+	c.mu.RUnlock()
+	if g != nil {
+		return g
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g = c.inFlight[key]; g == nil {
+		g = &atomic.Int64{}
+		c.inFlight[key] = g
+	}
+	return g
+}
+
+func (c *MetricsCollector) histogram(key seriesKey) *histogram {
+	c.mu.RLock()
+	h := c.requests[key]
+	c.mu.RUnlock()
+	if h != nil {
+		return h
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h = c.requests[key]; h == nil {
+		h = &histogram{counts: make([]atomic.Uint64, len(c.buckets)+1)}
+		c.requests[key] = h
+	}
+	return h
+}
+
+// Handler returns a handler exposing the metrics in the Prometheus
+// text format.
+func (c *MetricsCollector) Handler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		c.write(bw)
+		return http.StatusOK, bw.Flush()
+	}
+}
+
+// write renders the metrics, sorted by labels
+func (c *MetricsCollector) write(w *bufio.Writer) {
+	c.mu.RLock()
+	requests := make([]seriesKey, 0, len(c.requests))
+	for k := range c.requests {
+		requests = append(requests, k)
+	}
+	inFlight := make([]seriesKey, 0, len(c.inFlight))
+	for k := range c.inFlight {
+		inFlight = append(inFlight, k)
+	}
+	c.mu.RUnlock()
+	compare := func(a, b seriesKey) int {
+		return cmp.Or(cmp.Compare(a.route, b.route), cmp.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	}
+	slices.SortFunc(requests, compare)
+	slices.SortFunc(inFlight, compare)
+
+	name := c.prefix + "http_requests_total"
+	w.WriteString("# HELP " + name + " Total number of HTTP requests.\n# TYPE " + name + " counter\n")
+	for _, k := range requests {
+		h := c.histogram(k)
+		writeSample(w, name, labels(k, true), float64(h.count.Load()))
+	}
+
+	name = c.prefix + "http_request_duration_seconds"
+	w.WriteString("# HELP " + name + " Duration of HTTP requests in seconds.\n# TYPE " + name + " histogram\n")
+	for _, k := range requests {
+		h := c.histogram(k)
+		l := labels(k, true)
+		var cumulative uint64
+		for i := range h.counts {
+			cumulative += h.counts[i].Load()
+			le := "+Inf"
+			if i < len(c.buckets) {
+				le = formatValue(c.buckets[i])
+			}
+			writeSample(w, name+"_bucket", l+`,le="`+le+`"`, float64(cumulative))
+		}
+		writeSample(w, name+"_sum", l, math.Float64frombits(h.sum.Load()))
+		writeSample(w, name+"_count", l, float64(h.count.Load()))
+	}
+
+	name = c.prefix + "http_requests_in_flight"
+	w.WriteString("# HELP " + name + " Number of HTTP requests being served.\n# TYPE " + name + " gauge\n")
+	for _, k := range inFlight {
+		writeSample(w, name, labels(k, false), float64(c.gauge(k).Load()))
+	}
+}
+
+func labels(k seriesKey, status bool) string {
+	l := `method="` + escapeLabel(k.method) + `",route="` + escapeLabel(k.route) + `"`
+	if status {
+		l += `,status="` + strconv.Itoa(k.status) + `"`
+	}
+	return l
+}
+
+func writeSample(w *bufio.Writer, name string, labels string, v float64) {
+	w.WriteString(name + "{" + labels + "} " + formatValue(v) + "\n")
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,64 @@
+package heligo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+func TestMetrics(t *testing.T) {
+	c := heligo.NewMetricsCollector(heligo.MetricsOptions{Namespace: "app", Buckets: []float64{1, 0.5}})
+	router := heligo.New()
+	router.Use(heligo.Metrics(c))
+	var inFlight string
+	router.Handle("GET", "/users/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		if r.Param("id") == "0" {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/inflight", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		rec := httptest.NewRecorder()
+		c.Handler()(ctx, rec, r)
+		inFlight = rec.Body.String()
+		return http.StatusOK, nil
+	})
+	router.Handle("GET", "/metrics", c.Handler())
+
+	for _, url := range []string{"/users/1", "/users/2", "/users/0", "/inflight"} {
+		r, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if want := `app_http_requests_in_flight{method="GET",route="/inflight"} 1`; !strings.Contains(inFlight, want) {
+		t.Errorf("expected %q in\n%s", want, inFlight)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE app_http_requests_total counter\n",
+		`app_http_requests_total{method="GET",route="/users/:id",status="200"} 2` + "\n",
+		`app_http_requests_total{method="GET",route="/users/:id",status="404"} 1` + "\n",
+		"# TYPE app_http_request_duration_seconds histogram\n",
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="0.5"} 2` + "\n",
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="1"} 2` + "\n",
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="+Inf"} 2` + "\n",
+		`app_http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"} 2` + "\n",
+		"# TYPE app_http_requests_in_flight gauge\n",
+		`app_http_requests_in_flight{method="GET",route="/inflight"} 0` + "\n",
+		`app_http_requests_in_flight{method="GET",route="/metrics"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package heligo
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mountMethods are the methods routed to mounted handlers.
+// HEAD is served through the GET tree.
+var mountMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// Mount routes all the requests under prefix to h, for the standard methods.
+// The prefix, that can contain parameters, is stripped from the request path.
+// If h is a *Router its routes see the prefix parameters through
+// Request.Param, while its host groups and ErrorHandler are ignored:
+// errors are propagated to this router. For other handlers the prefix
+// parameters are available with ParamsFromContext.
+// Routes registered under the prefix on this router take precedence.
+// Mount panics if the prefix and host parameters with the parameters of a
+// route of h exceed MAXPARAMS: routes added to h later must stay within
+// the limit, or they never match.
+func (router *Router) Mount(prefix string, h http.Handler) {
+	router.mount("", prefix, h, nil)
+}
+
+// Mount routes all the requests under prefix to h, see Router.Mount.
+func (g *Group) Mount(prefix string, h http.Handler) {
+	g.router.mount(g.host, g.path+prefix, h, g.middlewares)
+}
+
+func (router *Router) mount(host string, prefix string, h http.Handler, middlewares []Middleware) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if child, ok := h.(*Router); ok {
+		n := countParams(prefix) + len(parseHost(host))
+		for _, rt := range child.Routes() {
+			if rt.Host == "" && n+countParams(rt.Pattern) > MAXPARAMS {
+				panic("heligo: too many parameters in path " + prefix + rt.Pattern)
+			}
+		}
+	}
+	exact := chain(mountHandler(h, false), middlewares)
+	wildcard := chain(mountHandler(h, true), middlewares)
+	for _, method := range mountMethods {
+		if prefix != "" {
+			router.handle(host, method, prefix, exact, nil)
+		}
+		router.handle(host, method, prefix+"/", exact, nil)
+		router.handle(host, method, prefix+"/*", wildcard, nil)
+	}
+}
+
+func mountHandler(h http.Handler, wildcard bool) Handler {
+	child, _ := h.(*Router)
+	return func(ctx context.Context, w http.ResponseWriter, r Request) (int, error) {
+		// the offset of the current URL path, when already mounted
+		base := max(len(r.path)-len(r.URL.Path), 0)
+		var offset int
+		if wildcard {
+			// drop the anonymous wildcard, keeping the leading slash
+			r.params.count--
+			offset = int(r.params.valueBeg[r.params.count]) - 1
+		} else if strings.HasSuffix(r.path, "/") {
+			offset = len(r.path) - 1
+		} else {
+			// the child routes see the prefix itself as "/"
+			offset = len(r.path)
+			r.path += "/"
+		}
+		req := r.Request.WithContext(ctx)
+		u := *req.URL
+		u.Path = r.path[offset:]
+		if r.params.escaped {
+			u.RawPath = u.Path
+			if p, err := url.PathUnescape(u.RawPath); err == nil {
+				u.Path = p
+			}
+		} else if u.RawPath != "" {
+			u.RawPath = u.RawPath[min(rawOffset(u.RawPath, offset-base), len(u.RawPath)):]
+			if u.RawPath == "" {
+				u.RawPath = "/"
+			}
+		}
+		req.URL = &u
+		r.Request = req
+
+		if child != nil {
+			return child.serveMounted(ctx, w, r, offset)
+		}
+		return Adapt(h)(ctx, w, r)
+	}
+}
+
+// serveMounted serves a request whose path, from offset, is routed by this router
+func (router *Router) serveMounted(ctx context.Context, w http.ResponseWriter, r Request, offset int) (int, error) {
+	r.params.nonEmpty = router.RejectEmptyParams
+	rt := router.getRoute(r.Request, r.path, offset, &r.params)
+	if rt == nil {
+		http.NotFound(w, r.Request)
+		return http.StatusNotFound, nil
+	}
+	r.route = rt
+	return rt.handler(ctx, w, r)
+}
+
+// rawOffset converts an offset in the decoded path to an offset in the
+// escaped one.
+func rawOffset(raw string, offset int) int {
+	i := 0
+	for j := 0; j < offset && i < len(raw); j++ {
+		if raw[i] == '%' {
+			i += 3
+		} else {
+			i++
+		}
+	}
+	return i
+}
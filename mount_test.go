@@ -0,0 +1,159 @@
+package heligo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+func TestMount(t *testing.T) {
+	child := heligo.New()
+	child.Handle("GET", "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fmt.Fprintf(w, "child root %s %s", r.URL.Path, r.Param("tenant"))
+		return 200, nil
+	})
+	child.Handle("GET", "/items/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fmt.Fprintf(w, "child item %s %v", r.URL.Path, r.Params())
+		return 200, nil
+	})
+	child.Handle("DELETE", "/items/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return http.StatusConflict, errors.New("conflict")
+	})
+	std := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "std %s %s %v", r.URL.Path, r.URL.RawPath, heligo.ParamsFromContext(r.Context()))
+	})
+
+	router := heligo.New()
+	var gotErr error
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		gotErr = err
+		w.WriteHeader(status)
+	}
+	router.Use(func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Header().Set("X-Parent", "yes")
+			return next(ctx, w, r)
+		}
+	})
+	router.Mount("/tenants/:tenant/", child)
+	router.Mount("/std", std)
+	router.Handle("GET", "/tenants/:tenant/special", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Write([]byte("parent special"))
+		return 200, nil
+	})
+	router.Group("/v1").Mount("/std", std)
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{"GET", "/tenants/acme", 200, "child root / acme"},
+		{"GET", "/tenants/acme/", 200, "child root / acme"},
+		{"GET", "/tenants/acme/items/7", 200, "child item /items/7 [{tenant acme} {id 7}]"},
+		{"HEAD", "/tenants/acme/items/7", 200, ""},
+		{"GET", "/tenants/acme/special", 200, "parent special"},
+		{"GET", "/tenants/acme/other", 404, ""},
+		{"POST", "/tenants/acme/items/7", 404, ""},
+		{"DELETE", "/tenants/acme/items/7", http.StatusConflict, ""},
+		{"GET", "/std/a/b", 200, "std /a/b  []"},
+		{"PUT", "/std", 200, "std /  []"},
+		{"GET", "/std/a%2Fb/c", 200, "std /a/b/c /a%2Fb/c []"},
+		{"GET", "/v1/std/x", 200, "std /x  []"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.status, w.Code)
+			continue
+		}
+		if test.status == 200 && test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: expected %q, got %q", test.method, test.url, test.body, w.Body.String())
+		}
+		if w.Header().Get("X-Parent") != "yes" {
+			t.Errorf("%s %s: parent middleware not applied", test.method, test.url)
+		}
+	}
+	if gotErr == nil || gotErr.Error() != "conflict" {
+		t.Errorf("expected the child error to reach the parent ErrorHandler, got %v", gotErr)
+	}
+}
+
+func TestMountNested(t *testing.T) {
+	leaf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %v", r.URL.Path, r.URL.RawPath, heligo.ParamsFromContext(r.Context()))
+	})
+	inner := heligo.New()
+	inner.Mount("/files/:owner", leaf)
+	outer := heligo.New()
+	outer.Mount("/api/:version", inner)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/v2/files/bob/x%2Fy", nil)
+	outer.ServeHTTP(w, r)
+	if want := "/x/y /x%2Fy [{version v2} {owner bob}]"; w.Body.String() != want {
+		t.Errorf("expected %q, got %q", want, w.Body.String())
+	}
+}
+
+func TestMountMaxParams(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < heligo.MAXPARAMS; i++ {
+		fmt.Fprintf(&sb, "/:p%d", i)
+	}
+	child := heligo.New()
+	child.Handle("GET", sb.String(), func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	})
+	router := heligo.New()
+	router.Mount("/static", child)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for too many parameters")
+		}
+	}()
+	router.Mount("/:tenant", child)
+}
+
+func TestMountRoot(t *testing.T) {
+	router := heligo.New()
+	router.Mount("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	for _, url := range []string{"/", "/a", "/a/b/"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != 200 || w.Body.String() != url {
+			t.Errorf("%s: expected 200 %q, got %d %q", url, url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMountRawPath(t *testing.T) {
+	child := heligo.New()
+	child.Handle("GET", "/items/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, r.URL.RawPath, r.Param("id"))
+		return 200, nil
+	})
+	router := heligo.New()
+	router.UseRawPath = true
+	router.Mount("/api", child)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/items/a%2Fb", nil)
+	router.ServeHTTP(w, r)
+	if want := "/items/a/b /items/a%2Fb a/b"; w.Code != 200 || w.Body.String() != want {
+		t.Errorf("expected %q, got %d %q", want, w.Code, w.Body.String())
+	}
+}
@@ -1,14 +1,121 @@
 package heligo
 
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
 type node struct {
 	text       string
 	children   []*node
 	childColon *node
+	childRe    []*node // the :param children with a constraint
 	childStar  *node
-	handler    Handler
-	param      string
+	route      *route         // the route without matchers
+	routes     []*route       // the routes with matchers
+	param      uint16         // the interned name of a parameter node
+	re         *regexp.Regexp // constraint of a :param node
+	suffix     bool           // a :param node followed by static text in the segment
+}
+
+// match returns the first route, in registration order, whose matchers
+// are all satisfied, or the route without matchers.
+func (n *node) match(r *http.Request) *route {
+	for _, rt := range n.routes {
+		if rt.matches(r) {
+			return rt
+		}
+	}
+	return n.route
+}
+
+func (n *node) hasHandler() bool {
+	return n.route != nil || len(n.routes) > 0
+}
+
+// clone returns a shallow copy of n, or a new node if n is nil.
+// The slices are clipped so that appending to them never touches
+// the arrays shared with n.
+func (n *node) clone() *node {
+	if n == nil {
+		return &node{}
+	}
+	c := *n
+	c.children = slices.Clone(n.children)
+	c.childRe = slices.Clone(n.childRe)
+	c.routes = slices.Clip(n.routes)
+	return &c
+}
+
+// isEmpty reports whether n has no routes and no children
+func (n *node) isEmpty() bool {
+	return !n.hasHandler() && len(n.children) == 0 && n.childColon == nil && len(n.childRe) == 0 && n.childStar == nil
+}
+
+// replaceChild replaces the child old of n with c
+func (n *node) replaceChild(old *node, c *node) {
+	switch {
+	case n.childColon == old:
+		n.childColon = c
+	case n.childStar == old:
+		n.childStar = c
+	default:
+		if i := slices.Index(n.children, old); i >= 0 {
+			n.children[i] = c
+		} else if i := slices.Index(n.childRe, old); i >= 0 {
+			n.childRe[i] = c
+		}
+	}
+}
+
+// prune removes the child c of n if empty, or merges it with its only
+// static child, after a route has been removed below c. k is the position
+// of the parameter of c in the path. Both n and c must be private copies.
+func (n *node) prune(c *node, k int) {
+	if c.isEmpty() {
+		n.replaceChild(c, nil)
+		n.children = slices.DeleteFunc(n.children, func(child *node) bool { return child == nil })
+		n.childRe = slices.DeleteFunc(n.childRe, func(child *node) bool { return child == nil })
+		return
+	}
+	switch c.text {
+	case string(COLON):
+		// the state set by the removed route
+		c.suffix = slices.ContainsFunc(c.children, func(child *node) bool { return child.text[0] != SLASH })
+		c.param = c.paramName(k)
+	case string(STAR):
+	default:
+		if !c.hasHandler() && len(c.children) == 1 && c.childColon == nil && len(c.childRe) == 0 && c.childStar == nil {
+			merged := c.children[0].clone()
+			merged.text = c.text + merged.text
+			n.replaceChild(c, merged)
+		}
+	}
+}
+
+// firstRoute returns a route of the tree of n
+func (n *node) firstRoute() *route {
+	if n.route != nil {
+		return n.route
+	}
+	if len(n.routes) > 0 {
+		return n.routes[0]
+	}
+	for _, child := range slices.Concat(n.children, n.childRe, []*node{n.childColon, n.childStar}) {
+		if child != nil {
+			if rt := child.firstRoute(); rt != nil {
+				return rt
+			}
+		}
+	}
+	return nil
 }
 
+// nextNode returns the child of n for s, splitting or creating nodes as needed.
+// n must be a private copy: the returned node and all the nodes modified
+// on the way are private copies too.
 func (n *node) nextNode(s string) *node {
 	slen := len(s)
 	if slen == 1 {
@@ -16,11 +123,15 @@ func (n *node) nextNode(s string) *node {
 		case COLON:
 			if n.childColon == nil {
 				n.childColon = &node{text: string(COLON)}
+			} else {
+				n.childColon = n.childColon.clone()
 			}
 			return n.childColon
 		case STAR:
 			if n.childStar == nil {
 				n.childStar = &node{text: string(STAR)}
+			} else {
+				n.childStar = n.childStar.clone()
 			}
 			return n.childStar
 		}
@@ -39,13 +150,17 @@ func (n *node) nextNode(s string) *node {
 				break
 			}
 		}
-		switch idx {
-		case 0:
+		if idx == 0 {
 			continue
+		}
+		child = child.clone()
+		switch idx {
 		case minlen:
 			if clen < slen {
+				n.children[i] = child
 				return child.nextNode(s[idx:])
 			} else if clen == slen {
+				n.children[i] = child
 				return child
 			} else {
 				n.children[i] = &node{text: s, children: []*node{child}}
@@ -78,49 +193,122 @@ func (n *node) findNode(s string, offset int, p *params) *node {
 				// backtrack
 				break
 			}
+		} else if !child.hasHandler() {
+			// an intermediate node, try the params
+			break
 		}
 		return child
 	}
-	if n.childColon != nil || n.childStar != nil {
+	if n.childColon != nil || n.childStar != nil || len(n.childRe) > 0 {
 		c := p.count
 		if c >= MAXPARAMS {
 			return nil
 		}
-		if n.childColon != nil {
-			child = n.childColon
-			p.names[c] = &child.param
-			k := 0
-			for {
-				if k == slen {
-					p.valueBeg[c] = uint16(offset)
-					p.valueEnd[c] = 0
-					p.count++
-					return child
+		if !(p.nonEmpty && (slen == 0 || s[0] == SLASH)) {
+			for _, child := range n.childRe {
+				if found := child.findParam(s, offset, p); found != nil {
+					return found
+				}
+			}
+			if child = n.childColon; child != nil && !child.suffix {
+				// the common case of findParam, inlined
+				end := 0
+				for end < slen && s[end] != SLASH {
+					end++
 				}
-				if s[k] == SLASH {
-					p.valueBeg[c] = uint16(offset)
-					p.valueEnd[c] = uint16(k)
-					p.count++
-					child = child.findNode(s[k:], offset+k, p)
-					if child != nil {
+				p.names[c] = child.param
+				p.valueBeg[c] = uint32(offset)
+				p.valueEnd[c] = uint32(offset + end)
+				p.count++
+				if end == slen {
+					if child.hasHandler() {
 						return child
-					} else {
-						// backtrack
-						p.count--
-						break
 					}
+				} else if found := child.findNode(s[end:], offset+end, p); found != nil {
+					return found
+				}
+				p.count--
+			} else if child != nil {
+				if found := child.findParam(s, offset, p); found != nil {
+					return found
 				}
-				k++
 			}
 		}
 		if n.childStar != nil {
 			child = n.childStar
-			p.names[c] = &child.param
-			p.valueBeg[c] = uint16(offset)
-			p.valueEnd[c] = 0
+			p.names[c] = child.param
+			p.valueBeg[c] = uint32(offset)
+			p.valueEnd[c] = uint32(offset + slen)
 			p.count++
 			return child
 		}
 	}
 	return nil
 }
+
+// findParam matches the value of the :param node n at the start of s,
+// and the rest of s with the children of n.
+func (n *node) findParam(s string, offset int, p *params) *node {
+	c := p.count
+	p.names[c] = n.param
+	p.valueBeg[c] = uint32(offset)
+	// segments are short: a loop is faster than strings.IndexByte
+	end := 0
+	for end < len(s) && s[end] != SLASH {
+		end++
+	}
+	if n.suffix {
+		// try the occurrences of the static text following the param in
+		// the segment, up to maxSplits for the whole lookup
+		for k := n.nextSplit(s[:end], 0); k >= 0 && p.splits < maxSplits; k = n.nextSplit(s[:end], k+1) {
+			if (k > 0 || !p.nonEmpty) && (n.re == nil || n.accepts(s[:k])) {
+				p.splits++
+				p.valueEnd[c] = uint32(offset + k)
+				p.count++
+				if found := n.findNode(s[k:], offset+k, p); found != nil {
+					return found
+				}
+				p.count--
+			}
+		}
+	}
+	if n.re != nil && !n.accepts(s[:end]) {
+		return nil
+	}
+	p.valueEnd[c] = uint32(offset + end)
+	p.count++
+	if end == len(s) {
+		if n.hasHandler() {
+			return n
+		}
+	} else if found := n.findNode(s[end:], offset+end, p); found != nil {
+		return found
+	}
+	// backtrack
+	p.count--
+	return nil
+}
+
+// maxSplits bounds the values tried for the :params followed by static
+// text in their segment, as chained ones, as in "/:a.:b.:c", would try a
+// polynomial number of them on pathological paths.
+const maxSplits = 256
+
+// nextSplit returns the first position from i in the segment seg where
+// the static text of a child of the :param node n occurs, -1 if none.
+func (n *node) nextSplit(seg string, i int) int {
+	k := -1
+	for _, child := range n.children {
+		text := child.text
+		if text[0] == SLASH {
+			continue
+		}
+		if j := strings.IndexByte(text, SLASH); j >= 0 {
+			text = text[:j]
+		}
+		if j := strings.Index(seg[min(i, len(seg)):], text); j >= 0 && (k < 0 || i+j < k) {
+			k = i + j
+		}
+	}
+	return k
+}
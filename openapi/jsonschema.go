@@ -0,0 +1,435 @@
+package openapi
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sted/heligo"
+)
+
+// maxDepth bounds the nesting of the validated values and schemas
+const maxDepth = 64
+
+// validator validates requests against a document
+type validator struct {
+	doc   *Document
+	paths map[string]pathEntry // by path template with anonymous parameters
+	// the path items of the route patterns, by pattern
+	patterns sync.Map
+	regexps  sync.Map
+}
+
+type pathEntry struct {
+	item  *PathItem
+	names []string
+}
+
+// patternEntry is the path item of a pattern with n path parameters
+type patternEntry struct {
+	n int
+	pathEntry
+}
+
+func newValidator(doc *Document) *validator {
+	v := &validator{doc: doc, paths: map[string]pathEntry{}}
+	for path, item := range doc.Paths {
+		key, names := anonymous(path)
+		if _, ok := v.paths[key]; !ok {
+			v.paths[key] = pathEntry{item, names}
+		}
+	}
+	return v
+}
+
+// anonymous removes the names of the parameters of a path template,
+// returning them
+func anonymous(path string) (string, []string) {
+	var b strings.Builder
+	var names []string
+	for {
+		beg := strings.IndexByte(path, '{')
+		end := strings.IndexByte(path, '}')
+		if beg < 0 || end < beg {
+			b.WriteString(path)
+			return b.String(), names
+		}
+		b.WriteString(path[:beg+1])
+		b.WriteByte('}')
+		names = append(names, path[beg+1:end])
+		path = path[end+1:]
+	}
+}
+
+// pathItem returns the path item for the request matching rt, with the
+// names of its path parameters
+func (v *validator) pathItem(rt heligo.Route, r *heligo.Request) (*PathItem, []string) {
+	if rt.Pattern == "" {
+		return nil, nil
+	}
+	entries, ok := v.patterns.Load(rt.Pattern)
+	if !ok {
+		var list []patternEntry
+		for _, tmpl := range templates(rt.Pattern) {
+			key, _ := anonymous(tmpl.path)
+			if e, ok := v.paths[key]; ok {
+				list = append(list, patternEntry{len(tmpl.params), e})
+			}
+		}
+		entries, _ = v.patterns.LoadOrStore(rt.Pattern, list)
+	}
+	// host parameters come first
+	n := len(r.Params()) - strings.Count("."+rt.Host, ".:")
+	for _, e := range entries.([]patternEntry) {
+		if e.n == n {
+			return e.item, e.names
+		}
+	}
+	return nil, nil
+}
+
+// parameter resolves a parameter reference
+func (v *validator) parameter(p *Parameter) *Parameter {
+	for i := 0; p != nil && p.Ref != "" && i < maxDepth; i++ {
+		name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+		if !ok || v.doc.Components == nil {
+			return nil
+		}
+		p = v.doc.Components.Parameters[name]
+	}
+	return p
+}
+
+// requestBody resolves the request body of op
+func (v *validator) requestBody(op *Operation) *RequestBody {
+	rb := op.RequestBody
+	for i := 0; rb != nil && rb.Ref != "" && i < maxDepth; i++ {
+		name, ok := strings.CutPrefix(rb.Ref, "#/components/requestBodies/")
+		if !ok || v.doc.Components == nil {
+			return nil
+		}
+		rb = v.doc.Components.RequestBodies[name]
+	}
+	return rb
+}
+
+// schema resolves a schema reference, nil if unresolvable
+func (v *validator) schema(s *Schema) *Schema {
+	for i := 0; s != nil && s.Ref != "" && i < maxDepth; i++ {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok || v.doc.Components == nil {
+			return nil
+		}
+		s = v.doc.Components.Schemas[name]
+	}
+	return s
+}
+
+func types(s *Schema) []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var list []string
+		for _, e := range t {
+			if name, ok := e.(string); ok {
+				list = append(list, name)
+			}
+		}
+		return list
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// coerce converts the string values of a parameter to the JSON values
+// expected by the schema
+func (v *validator) coerce(s *Schema, raw []string) any {
+	s = v.schema(s)
+	if s == nil {
+		return raw[0]
+	}
+	if slices.Contains(types(s), "array") {
+		if len(raw) == 1 && strings.Contains(raw[0], ",") {
+			raw = strings.Split(raw[0], ",")
+		}
+		values := make([]any, len(raw))
+		for i, r := range raw {
+			values[i] = v.coerce(s.Items, []string{r})
+		}
+		return values
+	}
+	for _, t := range types(s) {
+		switch t {
+		case "integer", "number":
+			if _, err := strconv.ParseFloat(raw[0], 64); err == nil {
+				return json.Number(raw[0])
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(raw[0]); err == nil {
+				return b
+			}
+		}
+	}
+	return raw[0]
+}
+
+// schemaError is a validation error at a JSON pointer
+type schemaError struct {
+	ptr    string
+	detail string
+}
+
+// validate returns the errors of value against s, appended to errs
+func (v *validator) validate(s *Schema, value any, ptr string, errs []schemaError) []schemaError {
+	return v.check(s, value, ptr, errs, 0)
+}
+
+func (v *validator) check(s *Schema, value any, ptr string, errs []schemaError, depth int) []schemaError {
+	if depth > maxDepth {
+		return append(errs, schemaError{ptr, "is nested too deeply"})
+	}
+	depth++
+	if s == nil {
+		return errs
+	}
+	if s = v.schema(s); s == nil {
+		return append(errs, schemaError{ptr, "has an unresolved schema"})
+	}
+	if s.never {
+		return append(errs, schemaError{ptr, "is not allowed"})
+	}
+	fail := func(detail string) {
+		errs = append(errs, schemaError{ptr, detail})
+	}
+
+	ts := types(s)
+	if value == nil && (s.Nullable || slices.Contains(ts, "null")) {
+		return errs
+	}
+	if len(ts) > 0 && !slices.ContainsFunc(ts, func(t string) bool { return hasType(value, t) }) {
+		fail("must be of type " + strings.Join(ts, " or "))
+		return errs
+	}
+	if s.Enum != nil && !slices.ContainsFunc(s.Enum, func(e any) bool { return equal(e, value) }) {
+		fail("must be one of the allowed values")
+	}
+	if s.Const != nil && !equal(s.Const, value) {
+		fail("must be equal to the constant value")
+	}
+
+	switch value := value.(type) {
+	case string:
+		n := utf8.RuneCountInString(value)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("must be at least " + strconv.Itoa(*s.MinLength) + " characters long")
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must be at most " + strconv.Itoa(*s.MaxLength) + " characters long")
+		}
+		if s.Pattern != "" {
+			if re := v.regexp(s.Pattern); re != nil && !re.MatchString(value) {
+				fail("must match the pattern " + s.Pattern)
+			}
+		}
+		if !validFormat(s.Format, value) {
+			fail("must be a valid " + s.Format)
+		}
+	case json.Number:
+		f, _ := value.Float64()
+		min, exclusive := bound(s.Minimum, s.ExclusiveMinimum)
+		if min != nil && (f < *min || exclusive && f == *min) {
+			fail("must be greater than " + orEqual(!exclusive) + formatFloat(*min))
+		}
+		max, exclusive := bound(s.Maximum, s.ExclusiveMaximum)
+		if max != nil && (f > *max || exclusive && f == *max) {
+			fail("must be less than " + orEqual(!exclusive) + formatFloat(*max))
+		}
+	case []any:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("must have at least " + strconv.Itoa(*s.MinItems) + " items")
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("must have at most " + strconv.Itoa(*s.MaxItems) + " items")
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				errs = v.check(s.Items, item, ptr+"/"+strconv.Itoa(i), errs, depth)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				errs = append(errs, schemaError{ptr + "/" + escapePointer(name), "is required"})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			p := ptr + "/" + escapePointer(name)
+			if ps, ok := s.Properties[name]; ok {
+				errs = v.check(ps, value[name], p, errs, depth)
+			} else if s.AdditionalProperties != nil {
+				errs = v.check(s.AdditionalProperties, value[name], p, errs, depth)
+			}
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		errs = v.check(sub, value, ptr, errs, depth)
+	}
+	if len(s.AnyOf) > 0 && v.matching(s.AnyOf, value, ptr, depth) == 0 {
+		fail("must match at least one schema")
+	}
+	if len(s.OneOf) > 0 && v.matching(s.OneOf, value, ptr, depth) != 1 {
+		fail("must match exactly one schema")
+	}
+	if s.Not != nil && len(v.check(s.Not, value, ptr, nil, depth)) == 0 {
+		fail("must not match the schema")
+	}
+	return errs
+}
+
+// matching counts the schemas that value satisfies
+func (v *validator) matching(list []*Schema, value any, ptr string, depth int) int {
+	n := 0
+	for _, s := range list {
+		if len(v.check(s, value, ptr, nil, depth)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *validator) regexp(expr string) *regexp.Regexp {
+	if re, ok := v.regexps.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		// invalid patterns are ignored
+		return nil
+	}
+	v.regexps.Store(expr, re)
+	return re
+}
+
+func hasType(value any, t string) bool {
+	switch value := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		f, err := value.Float64()
+		return t == "integer" && err == nil && f == math.Trunc(f)
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+// equal compares JSON values, numbers by value
+func equal(a, b any) bool {
+	if na, ok := number(a); ok {
+		nb, ok := number(b)
+		return ok && na == nb
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !equal(va, vb) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// bound returns the limit of a minimum or maximum, and whether it's exclusive.
+// exclusive is a number in OpenAPI 3.1, a boolean modifier in OpenAPI 3.0.
+func bound(limit *float64, exclusive any) (*float64, bool) {
+	switch e := exclusive.(type) {
+	case bool:
+		return limit, e && limit != nil
+	case float64:
+		if limit == nil || e >= *limit {
+			return &e, true
+		}
+	}
+	return limit, false
+}
+
+func orEqual(b bool) string {
+	if b {
+		return "or equal to "
+	}
+	return ""
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the common formats, accepting the unknown ones
+func validFormat(format string, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	case "uuid":
+		return uuidRegexp.MatchString(s)
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
+
+// escapePointer escapes a JSON pointer token
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,259 @@
+// Package openapi generates an OpenAPI 3.1 document from the routes
+// registered on a heligo.Router.
+//
+// Path patterns are converted to path templates: ":id" and "*path" become
+// "{id}" and "{path}", constraints become schema patterns and an optional
+// last segment, as in "/posts/:id?", produces the paths with and without it.
+// Routes are described with the Doc option:
+//
+//	router.Handle("POST", "/users", CreateUser, heligo.Name("createUser"),
+//		openapi.Doc(openapi.Spec{
+//			Summary:   "Create a user",
+//			Tags:      []string{"users"},
+//			Request:   NewUser{},
+//			Responses: map[int]any{201: User{}, 409: nil},
+//		}))
+//	openapi.Register(router, "/openapi.json", openapi.Config{Title: "Users", Version: "1.0"})
+//
+// The route name, if set, is the operationId.
+package openapi
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/sted/heligo"
+)
+
+// Version is the OpenAPI version of the generated documents
+const Version = "3.1.0"
+
+const metaKey = "openapi"
+
+// Spec describes a route, see Doc.
+type Spec struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Request is a value of the type of the JSON request body, nil if none
+	Request any
+	// Query is a struct value whose fields, named by their "query" tag or
+	// by their json name, are the query parameters
+	Query any
+	// Responses maps the status codes to a value of the type of the JSON
+	// response body, nil for no body. By default a 200 response.
+	Responses  map[int]any
+	Deprecated bool
+	// Hidden excludes the route from the document
+	Hidden bool
+}
+
+// Doc attaches spec to a route, as route metadata.
+func Doc(spec Spec) heligo.RouteOption {
+	return heligo.Meta(metaKey, &spec)
+}
+
+// Hidden excludes a route from the document.
+func Hidden() heligo.RouteOption {
+	return Doc(Spec{Hidden: true})
+}
+
+// Config configures the generated document.
+type Config struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	// Host is the host pattern of the documented routes,
+	// the default host if empty
+	Host string
+}
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem holds the operations of a path
+type PathItem struct {
+	Summary     string       `json:"summary,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Parameters  []*Parameter `json:"parameters,omitempty"`
+	Get         *Operation   `json:"get,omitempty"`
+	Put         *Operation   `json:"put,omitempty"`
+	Post        *Operation   `json:"post,omitempty"`
+	Delete      *Operation   `json:"delete,omitempty"`
+	Options     *Operation   `json:"options,omitempty"`
+	Head        *Operation   `json:"head,omitempty"`
+	Patch       *Operation   `json:"patch,omitempty"`
+	Trace       *Operation   `json:"trace,omitempty"`
+}
+
+// Operation returns the field of item for method,
+// nil if OpenAPI can't describe the method.
+func (item *PathItem) Operation(method string) **Operation {
+	switch method {
+	case http.MethodGet:
+		return &item.Get
+	case http.MethodPut:
+		return &item.Put
+	case http.MethodPost:
+		return &item.Post
+	case http.MethodDelete:
+		return &item.Delete
+	case http.MethodOptions:
+		return &item.Options
+	case http.MethodHead:
+		return &item.Head
+	case http.MethodPatch:
+		return &item.Patch
+	case http.MethodTrace:
+		return &item.Trace
+	}
+	return nil
+}
+
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []*Parameter        `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Components struct {
+	Schemas       map[string]*Schema      `json:"schemas,omitempty"`
+	Parameters    map[string]*Parameter   `json:"parameters,omitempty"`
+	RequestBodies map[string]*RequestBody `json:"requestBodies,omitempty"`
+}
+
+// Generate returns the document for the routes currently registered on router.
+// Routes sharing method and path, distinguished by matchers, are described
+// by the first one.
+func Generate(router *heligo.Router, cfg Config) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: cfg.Title, Version: cfg.Version, Description: cfg.Description},
+		Paths:   map[string]*PathItem{},
+	}
+	for _, s := range cfg.Servers {
+		doc.Servers = append(doc.Servers, Server{URL: s})
+	}
+	schemas := newSchemas()
+	for _, rt := range router.Routes() {
+		if rt.Host != cfg.Host {
+			continue
+		}
+		spec, _ := rt.Meta[metaKey].(*Spec)
+		if spec == nil {
+			spec = &Spec{}
+		} else if spec.Hidden {
+			continue
+		}
+		for _, tmpl := range templates(rt.Pattern) {
+			item := doc.Paths[tmpl.path]
+			if item == nil {
+				item = &PathItem{}
+				doc.Paths[tmpl.path] = item
+			}
+			if op := item.Operation(rt.Method); op != nil && *op == nil {
+				*op = operation(rt, spec, tmpl.params, schemas)
+			}
+		}
+	}
+	if len(schemas.defs) > 0 {
+		doc.Components = &Components{Schemas: schemas.defs}
+	}
+	return doc
+}
+
+func operation(rt heligo.Route, spec *Spec, params []*Parameter, schemas *schemas) *Operation {
+	op := &Operation{
+		OperationID: rt.Name,
+		Summary:     spec.Summary,
+		Description: spec.Description,
+		Tags:        spec.Tags,
+		Parameters:  params,
+		Responses:   map[string]Response{},
+		Deprecated:  spec.Deprecated,
+	}
+	if spec.Query != nil {
+		op.Parameters = append(op.Parameters, schemas.query(reflect.TypeOf(spec.Query))...)
+	}
+	if spec.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: schemas.of(reflect.TypeOf(spec.Request))}},
+		}
+	}
+	if len(spec.Responses) == 0 {
+		op.Responses["200"] = Response{Description: http.StatusText(http.StatusOK)}
+	}
+	for status, body := range spec.Responses {
+		resp := Response{Description: http.StatusText(status)}
+		if body != nil {
+			resp.Content = map[string]MediaType{"application/json": {Schema: schemas.of(reflect.TypeOf(body))}}
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+	}
+	return op
+}
+
+// Handler returns a handler serving the document of router as JSON.
+// The document is generated for each request, reflecting the routes
+// registered at that time.
+func Handler(router *heligo.Router, cfg Config) heligo.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return heligo.WriteJSON(w, http.StatusOK, Generate(router, cfg))
+	}
+}
+
+// Register serves the document of router at path, for GET requests.
+// The route itself is hidden from the document.
+func Register(router *heligo.Router, path string, cfg Config) {
+	router.Handle(http.MethodGet, path, Handler(router, cfg), Hidden())
+}
@@ -0,0 +1,131 @@
+package openapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+	"github.com/sted/heligo/openapi"
+)
+
+type User struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name" doc:"the display name"`
+	Email    string    `json:"email,omitempty"`
+	Created  time.Time `json:"created"`
+	Manager  *User     `json:"manager,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Avatar   []byte    `json:"avatar,omitempty"`
+	Hash     [4]byte   `json:"hash,omitempty"`
+	password string
+}
+
+type NewUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type ListQuery struct {
+	Limit  int    `query:"limit" doc:"the page size"`
+	Cursor string `json:"cursor"`
+}
+
+func h(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+	return http.StatusOK, nil
+}
+
+func TestGenerate(t *testing.T) {
+	router := heligo.New()
+	router.Handle("GET", "/users", h, openapi.Doc(openapi.Spec{
+		Summary:   "List users",
+		Tags:      []string{"users"},
+		Query:     ListQuery{},
+		Responses: map[int]any{200: []User{}},
+	}))
+	router.Handle("POST", "/users", h, heligo.Name("createUser"), openapi.Doc(openapi.Spec{
+		Request:   NewUser{},
+		Responses: map[int]any{201: User{}, 409: nil},
+	}))
+	router.Handle("GET", "/users/:id{[0-9]+}", h)
+	router.Handle("GET", "/posts/:slug?", h)
+	router.Handle("GET", "/files/*filepath", h)
+	router.Handle("GET", "/internal", h, openapi.Hidden())
+	router.Host("admin.example.com").Handle("GET", "/stats", h)
+	openapi.Register(router, "/openapi.json", openapi.Config{Title: "Users", Version: "1.0"})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != "3.1.0" || doc.Info.Title != "Users" {
+		t.Errorf("unexpected header %q %+v", doc.OpenAPI, doc.Info)
+	}
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	want := map[string]bool{"/users": true, "/users/{id}": true, "/posts/{slug}": true, "/posts": true, "/files/{filepath}": true}
+	if len(paths) != len(want) {
+		t.Errorf("expected paths %v, got %v", want, paths)
+	}
+	for _, p := range paths {
+		if !want[p] {
+			t.Errorf("unexpected path %s", p)
+		}
+	}
+
+	list := doc.Paths["/users"].Get
+	if list.Summary != "List users" || len(list.Parameters) != 2 || list.Parameters[0].Name != "limit" || list.Parameters[1].Name != "cursor" {
+		t.Errorf("unexpected list operation %+v", list)
+	}
+	if items := list.Responses["200"].Content["application/json"].Schema; items.Type != "array" || items.Items.Ref != "#/components/schemas/User" {
+		t.Errorf("unexpected list response %+v", items)
+	}
+	create := doc.Paths["/users"].Post
+	if create.OperationID != "createUser" || create.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/NewUser" {
+		t.Errorf("unexpected create operation %+v", create)
+	}
+	if _, ok := create.Responses["409"]; !ok || create.Responses["409"].Content != nil {
+		t.Errorf("expected a 409 response without content, got %+v", create.Responses)
+	}
+	if p := doc.Paths["/users/{id}"].Get.Parameters[0]; p.Name != "id" || p.In != "path" || !p.Required || p.Schema.Pattern != "^(?:[0-9]+)$" {
+		t.Errorf("unexpected path parameter %+v", p)
+	}
+	if ps := doc.Paths["/posts"].Get.Parameters; len(ps) != 0 {
+		t.Errorf("expected no parameters without the optional segment, got %v", ps)
+	}
+
+	user := doc.Components.Schemas["User"]
+	if !reflect.DeepEqual(user.Required, []string{"id", "name", "created"}) {
+		t.Errorf("unexpected required fields %v", user.Required)
+	}
+	if user.Properties["manager"].Ref != "#/components/schemas/User" ||
+		user.Properties["created"].Format != "date-time" ||
+		user.Properties["name"].Description != "the display name" ||
+		user.Properties["password"] != nil {
+		t.Errorf("unexpected properties %+v", user.Properties)
+	}
+	if avatar := user.Properties["avatar"]; avatar.Type != "string" || avatar.Format != "byte" {
+		t.Errorf("expected a base64 string for []byte, got %+v", avatar)
+	}
+	if hash := user.Properties["hash"]; hash.Type != "array" || hash.Items.Type != "integer" || *hash.MinItems != 4 || *hash.MaxItems != 4 {
+		t.Errorf("expected an array of 4 integers for [4]byte, got %+v", hash)
+	}
+
+	admin := openapi.Generate(router, openapi.Config{Host: "admin.example.com"})
+	if len(admin.Paths) != 1 || admin.Paths["/stats"].Get == nil {
+		t.Errorf("expected the admin host paths, got %v", admin.Paths)
+	}
+}
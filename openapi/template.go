@@ -0,0 +1,49 @@
+package openapi
+
+import (
+	"strings"
+
+	"github.com/sted/heligo"
+)
+
+// template is an OpenAPI path template with its path parameters
+type template struct {
+	path   string
+	params []*Parameter
+}
+
+// templates converts a heligo pattern to path templates: two if the last
+// segment is optional.
+func templates(pattern string) []template {
+	var b strings.Builder
+	var params []*Parameter
+	optional := -1 // the start of the optional segment in b
+	for _, part := range heligo.ParsePattern(pattern) {
+		if part.Static != "" {
+			b.WriteString(part.Static)
+			continue
+		}
+		name := part.Param
+		if part.Wildcard && name == "" {
+			name = "path"
+		}
+		schema := &Schema{Type: "string"}
+		if part.Constraint != "" {
+			schema.Pattern = "^(?:" + part.Constraint + ")$"
+		}
+		if part.Optional {
+			optional = strings.LastIndexByte(b.String(), '/')
+		}
+		b.WriteString("{" + name + "}")
+		params = append(params, &Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	path := b.String()
+	if optional < 0 {
+		return []template{{path, params}}
+	}
+	base := path[:optional]
+	if base == "" {
+		base = "/"
+	}
+	return []template{{path, params}, {base, params[:len(params)-1]}}
+}
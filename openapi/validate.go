@@ -0,0 +1,283 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sted/heligo"
+)
+
+// ErrInvalidRequest is wrapped by the errors returned by the Validate middleware
+var ErrInvalidRequest = errors.New("openapi: invalid request")
+
+// Load reads an OpenAPI 3.x JSON document from a file.
+func Load(name string) (*Document, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: %s: %w", name, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: %s: unsupported version %q", name, doc.OpenAPI)
+	}
+	return &doc, nil
+}
+
+// Problem is a RFC 9457 Problem Details response.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes a value failing validation.
+type FieldError struct {
+	// In is where the value is: path, query, header, cookie or body
+	In string `json:"in"`
+	// Parameter is the name of the parameter, for values not in the body
+	Parameter string `json:"parameter,omitempty"`
+	// Pointer is the JSON pointer of the value in the body, or in the
+	// parameter for arrays
+	Pointer string `json:"pointer,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+// ValidationError is returned by the Validate middleware, to be rendered
+// by the router ErrorHandler with WriteProblem.
+type ValidationError struct {
+	Problem
+}
+
+func (e *ValidationError) Error() string {
+	msg := ErrInvalidRequest.Error() + ": " + e.Detail
+	for _, fe := range e.Errors {
+		msg += "; " + fe.In + " " + fe.Parameter + fe.Pointer + ": " + fe.Detail
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// ValidateOptions configures Validate.
+type ValidateOptions struct {
+	// MaxBodySize limits the validated bodies, 1MB if zero:
+	// larger bodies are rejected with 413
+	MaxBodySize int64
+}
+
+// Validate returns a middleware validating the requests against doc before
+// the handlers run: path, query, header and cookie parameters and JSON
+// bodies, with a subset of JSON Schema (types, enum, const, formats,
+// patterns, bounds, properties, items and compositions).
+// For invalid requests the middleware returns the status, usually 400, and
+// a *ValidationError for the ErrorHandler, without writing the response.
+//
+// The operation is found by the pattern of the matched route, whose
+// parameters map to the ones of the document by position: "/users/:id"
+// matches "/users/{userId}". Routes not in the document, including the
+// routes of mounted routers, are not validated.
+func Validate(doc *Document, opts ValidateOptions) heligo.Middleware {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	v := newValidator(doc)
+	return func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			rt := r.Route()
+			item, names := v.pathItem(rt, &r)
+			if item == nil {
+				return next(ctx, w, r)
+			}
+			op := item.Operation(r.Method)
+			if op == nil || *op == nil {
+				return next(ctx, w, r)
+			}
+			if p := v.request(&r, item, *op, names, opts.MaxBodySize); p != nil {
+				return p.Status, &ValidationError{*p}
+			}
+			return next(ctx, w, r)
+		}
+	}
+}
+
+// WriteProblem writes the Problem of a ValidationError as
+// application/problem+json, reporting whether err is one.
+// It is meant for the router ErrorHandler:
+//
+//	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+//		if !openapi.WriteProblem(w, err) {
+//			http.Error(w, err.Error(), status)
+//		}
+//	}
+func WriteProblem(w http.ResponseWriter, err error) bool {
+	ve, ok := errors.AsType[*ValidationError](err)
+	if !ok {
+		return false
+	}
+	body, _ := json.Marshal(&ve.Problem)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(ve.Status)
+	w.Write(body)
+	return true
+}
+
+func problem(status int, detail string, errs []FieldError) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Errors: errs}
+}
+
+// request validates r against op, returning the problem found
+func (v *validator) request(r *heligo.Request, item *PathItem, op *Operation, names []string, maxBody int64) *Problem {
+	var errs []FieldError
+
+	// path parameters, by position
+	values := r.Params()
+	values = values[len(values)-len(names):]
+	path := map[string]string{}
+	for i, name := range names {
+		path[name] = values[i].Value
+	}
+
+	query := r.URL.Query()
+	for _, p := range v.parameters(item, op) {
+		var raw []string
+		switch p.In {
+		case "path":
+			if s, ok := path[p.Name]; ok {
+				raw = []string{s}
+			}
+		case "query":
+			raw = query[p.Name]
+		case "header":
+			name := http.CanonicalHeaderKey(p.Name)
+			if name == "Accept" || name == "Content-Type" || name == "Authorization" {
+				continue
+			}
+			for _, h := range r.Header.Values(name) {
+				for s := range strings.SplitSeq(h, ",") {
+					raw = append(raw, strings.TrimSpace(s))
+				}
+			}
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				raw = []string{c.Value}
+			}
+		default:
+			continue
+		}
+		if len(raw) == 0 {
+			if p.Required || p.In == "path" {
+				errs = append(errs, FieldError{In: p.In, Parameter: p.Name, Detail: "is required"})
+			}
+			continue
+		}
+		if p.Schema == nil {
+			continue
+		}
+		value := v.coerce(p.Schema, raw)
+		for _, e := range v.validate(p.Schema, value, "", nil) {
+			errs = append(errs, FieldError{In: p.In, Parameter: p.Name, Pointer: e.ptr, Detail: e.detail})
+		}
+	}
+
+	if body := v.requestBody(op); body != nil {
+		p, bodyErrs := v.body(r, body, maxBody)
+		if p != nil {
+			return p
+		}
+		errs = append(errs, bodyErrs...)
+	}
+	if len(errs) > 0 {
+		return problem(http.StatusBadRequest, "the request does not match the API definition", errs)
+	}
+	return nil
+}
+
+// parameters returns the parameters of op, including the ones of the path
+// item that op doesn't override
+func (v *validator) parameters(item *PathItem, op *Operation) []*Parameter {
+	var params []*Parameter
+	seen := map[string]bool{}
+	for _, list := range [][]*Parameter{op.Parameters, item.Parameters} {
+		for _, p := range list {
+			p = v.parameter(p)
+			if p == nil || seen[p.In+" "+p.Name] {
+				continue
+			}
+			seen[p.In+" "+p.Name] = true
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// body validates the JSON body of r, restoring it for the handler
+func (v *validator) body(r *heligo.Request, rb *RequestBody, maxBody int64) (*Problem, []FieldError) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return problem(http.StatusBadRequest, "can't read the body", nil), nil
+	}
+	if int64(len(data)) > maxBody {
+		return problem(http.StatusRequestEntityTooLarge, "the body is too large", nil), nil
+	}
+	if len(data) == 0 {
+		if rb.Required {
+			return nil, []FieldError{{In: "body", Detail: "is required"}}
+		}
+		return nil, nil
+	}
+	ct := r.Header.Get("Content-Type")
+	mt, _, _ := mime.ParseMediaType(ct)
+	media, ok := mediaType(rb.Content, mt)
+	if !ok {
+		return problem(http.StatusUnsupportedMediaType, "unsupported content type "+ct, nil), nil
+	}
+	if media.Schema == nil || !isJSON(mt) {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return nil, []FieldError{{In: "body", Detail: "invalid JSON"}}
+	}
+	var errs []FieldError
+	for _, e := range v.validate(media.Schema, value, "", nil) {
+		errs = append(errs, FieldError{In: "body", Pointer: "#" + e.ptr, Detail: e.detail})
+	}
+	return nil, errs
+}
+
+// mediaType finds the content for mt, also with wildcards
+func mediaType(content map[string]MediaType, mt string) (MediaType, bool) {
+	if m, ok := content[mt]; ok {
+		return m, true
+	}
+	if typ, _, ok := strings.Cut(mt, "/"); ok {
+		if m, ok := content[typ+"/*"]; ok {
+			return m, true
+		}
+	}
+	m, ok := content["*/*"]
+	return m, ok
+}
+
+func isJSON(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,141 @@
+package openapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+	"github.com/sted/heligo/openapi"
+)
+
+func TestValidate(t *testing.T) {
+	doc, err := openapi.Load("testdata/petstore.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := heligo.New()
+	var gotErr error
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		gotErr = err
+		if !openapi.WriteProblem(w, err) {
+			http.Error(w, err.Error(), status)
+		}
+	}
+	router.Use(openapi.Validate(doc, openapi.ValidateOptions{MaxBodySize: 1024}))
+	echo := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		// the body is still readable after the validation
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		return http.StatusOK, nil
+	}
+	router.Handle("GET", "/pets", echo)
+	router.Handle("POST", "/pets", echo)
+	router.Handle("GET", "/pets/:id", echo)
+	router.Handle("GET", "/other", echo)
+
+	const uuid = "123e4567-e89b-12d3-a456-426614174000"
+	tests := []struct {
+		method string
+		url    string
+		header string
+		body   string
+		status int
+		errors []openapi.FieldError
+	}{
+		{"GET", "/pets?limit=10&tags=cat&tags=dog", "", "", 200, nil},
+		{"GET", "/pets?limit=0", "", "", 400, []openapi.FieldError{
+			{In: "query", Parameter: "limit", Detail: "must be greater than or equal to 1"},
+		}},
+		{"GET", "/pets?limit=x&tags=cat,bird", "", "", 400, []openapi.FieldError{
+			{In: "query", Parameter: "limit", Detail: "must be of type integer"},
+			{In: "query", Parameter: "tags", Pointer: "/1", Detail: "must be one of the allowed values"},
+		}},
+		{"GET", "/pets/7", "", "", 200, nil},
+		{"GET", "/pets/0", "", "", 400, []openapi.FieldError{
+			{In: "path", Parameter: "petId", Detail: "must be greater than 0"},
+		}},
+		{"GET", "/other?limit=x", "", "", 200, nil},
+		{"POST", "/pets", uuid, `{"name": "Rex", "kind": "dog", "birth": null, "owners": [{"email": "a@example.com"}]}`, 200, nil},
+		{"POST", "/pets", "", `{"name": "Rex", "kind": "dog"}`, 400, []openapi.FieldError{
+			{In: "header", Parameter: "X-Request-ID", Detail: "is required"},
+		}},
+		{"POST", "/pets", uuid, `{"name": "", "kind": "bird", "age": 3, "owners": [{"email": "nope"}, {}]}`, 400, []openapi.FieldError{
+			{In: "body", Pointer: "#/age", Detail: "is not allowed"},
+			{In: "body", Pointer: "#/kind", Detail: "must be one of the allowed values"},
+			{In: "body", Pointer: "#/name", Detail: "must be at least 1 characters long"},
+			{In: "body", Pointer: "#/owners/0/email", Detail: "must be a valid email"},
+			{In: "body", Pointer: "#/owners/1/email", Detail: "is required"},
+		}},
+		{"POST", "/pets", uuid, `{"name": "Rex"`, 400, []openapi.FieldError{
+			{In: "body", Detail: "invalid JSON"},
+		}},
+		{"POST", "/pets", uuid, "", 400, []openapi.FieldError{
+			{In: "body", Detail: "is required"},
+		}},
+		{"POST", "/pets", uuid, `{"name": "` + strings.Repeat("x", 2000) + `"}`, 413, nil},
+	}
+	for _, test := range tests {
+		gotErr = nil
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if test.header != "" {
+			r.Header.Set("X-Request-ID", test.header)
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d %s", test.method, test.url, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if test.status == 200 {
+			if w.Body.String() != test.body {
+				t.Errorf("%s %s: expected the body to reach the handler, got %q", test.method, test.url, w.Body.String())
+			}
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s %s: unexpected content type %q", test.method, test.url, ct)
+		}
+		var p openapi.Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != test.status || !reflect.DeepEqual(p.Errors, test.errors) {
+			t.Errorf("%s %s: expected errors %+v, got %+v", test.method, test.url, test.errors, p.Errors)
+		}
+		if !errors.Is(gotErr, openapi.ErrInvalidRequest) {
+			t.Errorf("%s %s: expected ErrInvalidRequest, got %v", test.method, test.url, gotErr)
+		}
+	}
+}
+
+func TestValidateUnsupportedMediaType(t *testing.T) {
+	doc, err := openapi.Load("testdata/petstore.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		openapi.WriteProblem(w, err)
+	}
+	router.Use(openapi.Validate(doc, openapi.ValidateOptions{}))
+	router.Handle("POST", "/pets", h)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/pets", strings.NewReader("name=Rex"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Request-ID", "123e4567-e89b-12d3-a456-426614174000")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("expected a 415 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
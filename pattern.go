@@ -0,0 +1,184 @@
+package heligo
+
+import "regexp"
+
+// Route patterns
+//
+// A pattern is made of static text and parameters:
+//
+//	:name         matches a part of a segment, up to the next "/" or to the
+//	              static text that follows it in the pattern, as in
+//	              "/files/:name.:ext" or "/v:version/items". The value can be
+//	              empty ("/files/.hidden" has name ""), unless the router
+//	              sets RejectEmptyParams
+//	:name{regexp} as :name, but the value must match the regular expression
+//	:name?        as last segment, makes it optional: "/posts/:id?" matches
+//	              both "/posts/1" and "/posts"
+//	*name         matches the non empty rest of the path
+//
+// Parameter names are made of letters, digits, '_' and '-'.
+//
+// Precedence: at each position static text is tried first, then the
+// constrained :params in registration order and the :param without
+// constraint, taking the shortest value for which the rest of the path
+// matches, and finally a *param. When a branch fails the lookup backtracks
+// to the next alternative. The values tried for the :params followed by
+// static text are bounded, so that pathological paths fail quickly.
+
+// isNameChar reports whether c can be part of a parameter name
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseParam parses the :param starting at i, returning its name,
+// the optional constraint expression and the end of the parameter.
+func parseParam(path string, i int) (name string, expr string, end int) {
+	end = i + 1
+	for end < len(path) && isNameChar(path[end]) {
+		end++
+	}
+	name = path[i+1 : end]
+	if end < len(path) && path[end] == '{' {
+		depth := 0
+		for j := end; j < len(path); j++ {
+			switch path[j] {
+			case '\\':
+				j++
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return name, path[end+1 : j], j + 1
+				}
+			}
+		}
+		panic("heligo: unterminated constraint in path " + path)
+	}
+	return name, "", end
+}
+
+// PatternPart is a part of a route pattern: static text or a parameter.
+type PatternPart struct {
+	Static     string // the static text, empty for a parameter
+	Param      string // the name of a parameter, empty for an anonymous *
+	Constraint string // the regular expression of a :param{regexp}
+	Wildcard   bool   // a *param
+	Optional   bool   // an optional last :param?
+}
+
+// ParsePattern splits a route pattern into static text and parameters,
+// for the tools describing the routes. It panics on an unterminated
+// constraint, as Handle.
+func ParsePattern(pattern string) []PatternPart {
+	_, optional := optionalBase(pattern)
+	if optional {
+		pattern = pattern[:len(pattern)-1]
+	}
+	var parts []PatternPart
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case COLON:
+			if start < i {
+				parts = append(parts, PatternPart{Static: pattern[start:i]})
+			}
+			name, expr, end := parseParam(pattern, i)
+			parts = append(parts, PatternPart{Param: name, Constraint: expr})
+			i, start = end-1, end
+		case STAR:
+			if start < i {
+				parts = append(parts, PatternPart{Static: pattern[start:i]})
+			}
+			return append(parts, PatternPart{Param: pattern[i+1:], Wildcard: true})
+		}
+	}
+	if start < len(pattern) {
+		parts = append(parts, PatternPart{Static: pattern[start:]})
+	}
+	if optional {
+		parts[len(parts)-1].Optional = true
+	}
+	return parts
+}
+
+// paramName returns the name of the k-th parameter of the routes below
+// the :param node n, that share it
+func (n *node) paramName(k int) uint16 {
+	rt := n.firstRoute()
+	if rt == nil {
+		return n.param
+	}
+	path := rt.Pattern
+	for i := 0; i < len(path); i++ {
+		if path[i] == COLON {
+			name, _, end := parseParam(path, i)
+			if k == 0 {
+				return internName(name)
+			}
+			k--
+			i = end - 1
+		}
+	}
+	return n.param
+}
+
+// countParams counts the parameters in a route path
+func countParams(path string) int {
+	n := 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case COLON:
+			n++
+			_, _, end := parseParam(path, i)
+			i = end - 1
+		case STAR:
+			return n + 1
+		}
+	}
+	return n
+}
+
+// optionalBase returns the path without its last segment if this is
+// an optional parameter, as in "/posts/:id?".
+func optionalBase(path string) (string, bool) {
+	if len(path) < 3 || path[len(path)-1] != '?' {
+		return "", false
+	}
+	last := len(path) - 2
+	for last > 0 && path[last] != SLASH {
+		last--
+	}
+	if path[last+1] != COLON {
+		return "", false
+	}
+	if last == 0 {
+		return "/", true
+	}
+	return path[:last], true
+}
+
+// paramNode returns the :param child of n with the constraint expr,
+// creating it if missing. n must be a private copy, as for nextNode.
+func (n *node) paramNode(expr string) *node {
+	if expr == "" {
+		return n.nextNode(string(COLON))
+	}
+	expr = "^(?:" + expr + ")$"
+	for i, child := range n.childRe {
+		if child.re.String() == expr {
+			child = child.clone()
+			n.childRe[i] = child
+			return child
+		}
+	}
+	child := &node{text: string(COLON), re: regexp.MustCompile(expr)}
+	n.childRe = append(n.childRe, child)
+	return child
+}
+
+// accepts reports whether v satisfies the constraint of a :param node.
+// The callers check n.re first, as this is not inlined.
+func (n *node) accepts(v string) bool {
+	return n.re == nil || n.re.MatchString(v)
+}
@@ -0,0 +1,184 @@
+package heligo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestPatterns(t *testing.T) {
+	router := heligo.New()
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			for _, p := range r.Params() {
+				w.Write([]byte(" " + p.Name + "=" + p.Value))
+			}
+			return 200, nil
+		}
+	}
+	router.Handle("GET", "/files/:name.:ext", handler("file"))
+	router.Handle("GET", "/files/:name.tar.gz", handler("tarball"))
+	router.Handle("GET", "/files/readme.md", handler("readme"))
+	router.Handle("GET", "/files/*path", handler("any"))
+	router.Handle("GET", "/v:version/items", handler("items"))
+	router.Handle("GET", "/@:username", handler("user"))
+	router.Handle("GET", "/posts/:id?", handler("posts"))
+	router.Handle("GET", "/orders/:id{[0-9]+}", handler("order"))
+	router.Handle("GET", "/orders/:id{[0-9]+}/lines/:line{\\d{1,3}}", handler("line"))
+	router.Handle("GET", "/orders/*rest", handler("orders"))
+	router.Handle("GET", "/range/:from..:to", handler("range"))
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/files/report.pdf", 200, "file name=report ext=pdf"},
+		{"/files/archive.tar.gz", 200, "tarball name=archive"},
+		{"/files/a.b.c", 200, "file name=a ext=b.c"},
+		{"/files/readme.md", 200, "readme"},
+		{"/files/noext", 200, "any path=noext"},
+		{"/files/dir/x.txt", 200, "any path=dir/x.txt"},
+		{"/files/.hidden", 200, "file name= ext=hidden"},
+		{"/v2/items", 200, "items version=2"},
+		{"/v/items", 200, "items version="},
+		{"/@alice", 200, "user username=alice"},
+		{"/posts/7", 200, "posts id=7"},
+		{"/posts", 200, "posts"},
+		{"/orders/42", 200, "order id=42"},
+		{"/orders/abc", 200, "orders rest=abc"},
+		{"/orders/42/lines/3", 200, "line id=42 line=3"},
+		{"/orders/42/lines/1234", 200, "orders rest=42/lines/1234"},
+		{"/range/1..9", 200, "range from=1 to=9"},
+		{"/range/1.2..9", 200, "range from=1.2 to=9"},
+		{"/range/1-9", 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status || test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.url, test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPatternErrors(t *testing.T) {
+	h := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	}
+	for _, pattern := range []string{"/a/:x:y", "/a/:x{[0-9]", "/a/:x*y"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", pattern)
+				}
+			}()
+			heligo.New().Handle("GET", pattern, h)
+		}()
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	router := heligo.New()
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			for _, p := range r.Params() {
+				w.Write([]byte(" " + p.Name + "=" + p.Value))
+			}
+			return 200, nil
+		}
+	}
+	router.Handle("GET", "/a/:id{[0-9]+}", handler("number"))
+	router.Handle("GET", "/a/:name/x", handler("x"))
+	router.Handle("GET", "/b/:n{[0-9]+}", handler("number"))
+	router.Handle("GET", "/b/:s{[a-z]+}/c", handler("letters"))
+	router.Handle("GET", "/b/:any", handler("any"))
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/a/42", 200, "number id=42"},
+		{"/a/foo/x", 200, "x name=foo"},
+		{"/a/42/x", 200, "x name=42"},
+		{"/a/foo", 404, ""},
+		{"/b/7", 200, "number n=7"},
+		{"/b/abc/c", 200, "letters s=abc"},
+		{"/b/abc", 200, "any any=abc"},
+		{"/b/7/c", 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status || test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.url, test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		parts   []heligo.PatternPart
+	}{
+		{"/", []heligo.PatternPart{{Static: "/"}}},
+		{"/files/:name.:ext", []heligo.PatternPart{{Static: "/files/"}, {Param: "name"}, {Static: "."}, {Param: "ext"}}},
+		{"/orders/:id{[0-9]+}/lines/:line{\\d{1,3}}", []heligo.PatternPart{
+			{Static: "/orders/"}, {Param: "id", Constraint: "[0-9]+"}, {Static: "/lines/"}, {Param: "line", Constraint: "\\d{1,3}"},
+		}},
+		{"/posts/:id?", []heligo.PatternPart{{Static: "/posts/"}, {Param: "id", Optional: true}}},
+		{"/files/*path", []heligo.PatternPart{{Static: "/files/"}, {Param: "path", Wildcard: true}}},
+		{"/static/*", []heligo.PatternPart{{Static: "/static/"}, {Wildcard: true}}},
+	}
+	for _, tt := range tests {
+		if parts := heligo.ParsePattern(tt.pattern); !reflect.DeepEqual(parts, tt.parts) {
+			t.Errorf("%s: expected %+v, got %+v", tt.pattern, tt.parts, parts)
+		}
+	}
+}
+
+func TestPatternsPathological(t *testing.T) {
+	router := heligo.New()
+	handler := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		for _, p := range r.Params() {
+			w.Write([]byte(p.Name + "=" + p.Value + " "))
+		}
+		return 200, nil
+	}
+	router.Handle("GET", "/:a.:b.:c.:d/z", handler)
+	router.Handle("GET", "/x/:a.:b.:c.:d.:e.:f.:g/z", handler)
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/" + strings.Repeat(".", 400) + "/y", 404, ""},
+		{"/x/" + strings.Repeat(".", 60) + "/y", 404, ""},
+		{"/" + strings.Repeat("a", 400) + "/z", 404, ""},
+		{"/1.2.3.4/z", 200, "a=1 b=2 c=3 d=4 "},
+		{"/1.2.3.4.5/z", 200, "a=1 b=2 c=3 d=4.5 "},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Errorf("%.20s...: lookup took %v", tt.url, d)
+		}
+		if w.Code != tt.status || tt.status == 200 && w.Body.String() != tt.body {
+			t.Errorf("%.20s...: expected %d %q, got %d %q", tt.url, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -3,40 +3,146 @@ package heligo
 import (
 	"encoding/json"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// MAXPARAMS is the maximum number of parameters in a route, including
+// the host parameters. Handle panics for patterns exceeding it.
 const MAXPARAMS = 16
 
+// params stores the parameter values as [valueBeg, valueEnd) ranges of the
+// routed path, or of the host for the first hostCount ones: an empty value
+// has valueBeg == valueEnd.
+// Offsets are 32 bits wide: paths are limited by the server header size,
+// and can be further limited with Router.MaxPathLength.
 type params struct {
-	names    [MAXPARAMS]*string
-	valueBeg [MAXPARAMS]uint16
-	valueEnd [MAXPARAMS]uint16
-	count    int
+	names     [MAXPARAMS]uint16 // see internName
+	valueBeg  [MAXPARAMS]uint32
+	valueEnd  [MAXPARAMS]uint32
+	count     uint8
+	hostCount uint8  // the first hostCount params refer to the host
+	nonEmpty  bool   // :params don't match empty segments
+	escaped   bool   // the routed path is escaped
+	splits    uint16 // the values tried for suffixed :params, see maxSplits
+}
+
+// paramNames interns the parameter names, so that params refers to them
+// with small indexes. It only grows, with the names of the registered routes.
+var paramNames struct {
+	mu    sync.Mutex
+	index map[string]uint16
+	list  atomic.Pointer[[]string]
+}
+
+func init() {
+	paramNames.index = map[string]uint16{"": 0}
+	paramNames.list.Store(&[]string{""})
+}
+
+// internName returns the index of a parameter name, adding it if missing.
+// The zero index is the empty name.
+func internName(name string) uint16 {
+	paramNames.mu.Lock()
+	defer paramNames.mu.Unlock()
+	if i, ok := paramNames.index[name]; ok {
+		return i
+	}
+	list := *paramNames.list.Load()
+	if len(list) > math.MaxUint16 {
+		panic("heligo: too many parameter names")
+	}
+	// readers never access the entries beyond their own list length
+	list = append(list, name)
+	i := uint16(len(list) - 1)
+	paramNames.index[name] = i
+	paramNames.list.Store(&list)
+	return i
+}
+
+// names returns the interned parameter names
+func names() []string {
+	return *paramNames.list.Load()
 }
 
 // Request embeds the standard http.Request and the URL parameters in a compressed format
+// (it is passed by value to every handler and middleware: keep it small)
 type Request struct {
 	*http.Request
 	params params
+	path   string // the routed path, the params refer to it
+	route  *route
+	_      [8]byte
+}
+
+// Route returns the description of the matched route.
+// For handlers of mounted routers it's the route of the mounted router.
+func (r *Request) Route() Route {
+	if r.route == nil {
+		return Route{}
+	}
+	return r.route.Route
 }
 
+// paramValue returns the value as found in the routed path
 func (r *Request) paramValue(i int) string {
-	beg := r.params.valueBeg[i]
-	end := r.params.valueEnd[i]
-	if end == 0 {
-		return r.Request.URL.Path[beg:]
-	} else {
-		return r.Request.URL.Path[beg : beg+end]
+	s := r.path
+	if i < int(r.params.hostCount) {
+		s = r.Request.Host
+	}
+	return s[r.params.valueBeg[i]:r.params.valueEnd[i]]
+}
+
+// unescapedValue returns the decoded value, when routing on the escaped path
+func (r *Request) unescapedValue(i int) string {
+	v := r.paramValue(i)
+	if r.params.escaped && i >= int(r.params.hostCount) {
+		return unescape(v)
+	}
+	return v
+}
+
+// unescape decodes an escaped path value, keeping it if invalid
+func unescape(v string) string {
+	if u, err := url.PathUnescape(v); err == nil {
+		return u
 	}
+	return v
 }
 
 // Param returns a URL parameter by name.
 // It returns an empty string if the requested parameter is not found.
 func (r *Request) Param(name string) string {
-	for i := 0; i < r.params.count; i++ {
-		if *r.params.names[i] == name {
-			return r.paramValue(i)
+	names := names()
+	for i := 0; i < int(r.params.count); i++ {
+		if names[r.params.names[i]] == name {
+			v := r.paramValue(i)
+			if r.params.escaped && i >= int(r.params.hostCount) {
+				return unescape(v)
+			}
+			return v
+		}
+	}
+	return ""
+}
+
+// RawParam returns a URL parameter by name, in its escaped form.
+// With Router.UseRawPath it is the value as sent by the client, otherwise
+// the value is escaped again, as the original escaping is lost.
+func (r *Request) RawParam(name string) string {
+	names := names()
+	for i := 0; i < int(r.params.count); i++ {
+		if names[r.params.names[i]] == name {
+			v := r.paramValue(i)
+			if r.params.escaped || i < int(r.params.hostCount) {
+				return v
+			}
+			// keep the slashes of wildcard params
+			return strings.ReplaceAll(url.PathEscape(v), "%2F", "/")
 		}
 	}
 	return ""
@@ -50,15 +156,15 @@ type Param struct {
 // ParamByPos gets a URL parameter by position in the URL (0-based)
 func (r *Request) ParamByPos(i int) Param {
 	var param Param
-	param.Name = *r.params.names[i]
-	param.Value = r.paramValue(i)
+	param.Name = names()[r.params.names[i]]
+	param.Value = r.unescapedValue(i)
 	return param
 }
 
 // Params gets all the URL parameters for the request
 func (r *Request) Params() []Param {
 	var params []Param
-	for i := 0; i < r.params.count; i++ {
+	for i := 0; i < int(r.params.count); i++ {
 		params = append(params, r.ParamByPos(i))
 	}
 	return params
@@ -3,9 +3,11 @@ package heligo_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/sted/heligo"
@@ -99,3 +101,137 @@ func TestReadJSON(t *testing.T) {
 	r, _ := http.NewRequest("POST", "/read1", bytes.NewBuffer([]byte(`{"String": "value", "Number": 42, "Bool": true, "Array": [1,2,3]}`)))
 	router.ServeHTTP(w, r)
 }
+
+func TestLongPathParams(t *testing.T) {
+	router := heligo.New()
+	var got []heligo.Param
+	router.Handle("GET", "/long/:a/:b/*c", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		got = r.Params()
+		return 200, nil
+	})
+	a := strings.Repeat("a", 70000)
+	b := strings.Repeat("b", 70000)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/long/"+a+"/"+b+"/c/d", nil)
+	router.ServeHTTP(w, r)
+	if len(got) != 3 || got[0].Value != a || got[1].Value != b || got[2].Value != "c/d" {
+		t.Errorf("wrong params for a long path")
+	}
+
+	router.MaxPathLength = 1024
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestURITooLong {
+		t.Errorf("expected 414, got %d", w.Code)
+	}
+
+	// the escaped path is measured when routing on it
+	r, _ = http.NewRequest("GET", "/long/a/b/"+strings.Repeat("%20", 400), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	router.UseRawPath = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestURITooLong {
+		t.Errorf("expected 414 for the escaped path, got %d", w.Code)
+	}
+}
+
+func TestMaxParams(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < heligo.MAXPARAMS; i++ {
+		fmt.Fprintf(&sb, "/:p%d", i)
+	}
+	router := heligo.New()
+	router.Handle("GET", sb.String(), func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for too many parameters")
+		}
+	}()
+	router.Host(":sub.example.com").Handle("GET", sb.String(), func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	})
+}
+
+func TestEmptyParams(t *testing.T) {
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			for _, p := range r.Params() {
+				w.Write([]byte(" " + p.Name + "=" + p.Value))
+			}
+			return 200, nil
+		}
+	}
+	tests := []struct {
+		reject bool
+		url    string
+		status int
+		body   string
+	}{
+		{false, "/users//posts", 200, "posts id="},
+		{false, "/users/", 404, ""},
+		{false, "/files/", 404, ""},
+		{true, "/users//posts", 200, "any rest=/posts"},
+		{true, "/users/", 404, ""},
+		{true, "/users/1/posts", 200, "posts id=1"},
+		{true, "/tags//", 404, ""},
+	}
+	for _, test := range tests {
+		router := heligo.New()
+		router.RejectEmptyParams = test.reject
+		router.Handle("GET", "/users/:id/posts", handler("posts"))
+		router.Handle("GET", "/users/:id", handler("user"))
+		router.Handle("GET", "/users/*rest", handler("any"))
+		router.Handle("GET", "/files/*path", handler("files"))
+		router.Handle("GET", "/tags/:tag/", handler("tag"))
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status || w.Body.String() != test.body && test.status == 200 {
+			t.Errorf("reject=%v %s: expected %d %q, got %d %q", test.reject, test.url, test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRawPath(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		fmt.Fprintf(w, "%s|%s|%s|%s", r.Param("id"), r.RawParam("id"), r.Param("rest"), r.RawParam("rest"))
+		return 200, nil
+	}
+	tests := []struct {
+		raw    bool
+		url    string
+		status int
+		body   string
+	}{
+		{true, "/files/a%2Fb/meta", 200, "a/b|a%2Fb||"},
+		{true, "/files/a%20b/meta", 200, "a b|a%20b||"},
+		{true, "/files/plain/meta", 200, "plain|plain||"},
+		{true, "/tree/x%2Fy/z", 200, "||x/y/z|x%2Fy/z"},
+		{false, "/files/a%2Fb/meta", 404, ""},
+		{false, "/files/a%20b/meta", 200, "a b|a%20b||"},
+		{false, "/tree/x%2Fy/z", 200, "||x/y/z|x/y/z"},
+	}
+	for _, test := range tests {
+		router := heligo.New()
+		router.UseRawPath = test.raw
+		router.Handle("GET", "/files/:id/meta", handler)
+		router.Handle("GET", "/tree/*rest", handler)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status || test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("raw=%v %s: expected %d %q, got %d %q", test.raw, test.url, test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package heligo
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter wraps a http.ResponseWriter, tracking the status,
+// the size of the body and whether the header has been written.
+//
+// It implements http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom,
+// delegating to the wrapped writer, and Unwrap for http.ResponseController.
+// Flush is a no-op and the others return http.ErrNotSupported when the
+// wrapped writer doesn't support them.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	written  bool
+	hijacked bool
+}
+
+// NewResponseWriter wraps w, or returns it if it is already a *ResponseWriter.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+	return &ResponseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// AdapterResponseWriter is the former name of ResponseWriter.
+//
+// Deprecated: use ResponseWriter.
+type AdapterResponseWriter = ResponseWriter
+
+// WriteHeader writes the header with code. The status of informational
+// responses is not recorded, and calls after the first are ignored.
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.written || w.hijacked {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Status returns the status written, 200 if none yet.
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of bytes of the body written.
+func (w *ResponseWriter) Size() int64 {
+	return w.size
+}
+
+// Written reports whether the header has been written
+// or the connection hijacked.
+func (w *ResponseWriter) Written() bool {
+	return w.written || w.hijacked
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends the buffered data to the client, writing the header if needed.
+func (w *ResponseWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection.
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push.
+func (w *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom copies r to the response, using the io.ReaderFrom of the
+// wrapped writer if available, as for sendfile.
+func (w *ResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// hide ReadFrom from io.Copy, to avoid the recursion
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.size += n
+	return n, err
+}
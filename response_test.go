@@ -0,0 +1,132 @@
+package heligo_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := heligo.NewResponseWriter(rec)
+	if heligo.NewResponseWriter(w) != w {
+		t.Error("expected an existing ResponseWriter to be reused")
+	}
+	if w.Written() || w.Status() != http.StatusOK {
+		t.Errorf("unexpected initial state %v %d", w.Written(), w.Status())
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, "hello ")
+	io.Copy(w, strings.NewReader("world"))
+	if !w.Written() || w.Status() != http.StatusCreated || w.Size() != 11 {
+		t.Errorf("expected written 201 of 11 bytes, got %v %d %d", w.Written(), w.Status(), w.Size())
+	}
+	if rec.Code != http.StatusCreated || rec.Body.String() != "hello world" {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil || !rec.Flushed {
+		t.Errorf("expected the flush to reach the recorder, got %v", err)
+	}
+	if _, _, err := rc.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+	if err := w.Push("/x", nil); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestResponseWriterImplicitStatus(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := heligo.NewResponseWriter(rec)
+	w.Flush()
+	if !w.Written() || w.Status() != http.StatusOK || !rec.Flushed {
+		t.Errorf("expected a flushed 200, got %v %d", w.Written(), w.Status())
+	}
+}
+
+func TestAdaptResponseController(t *testing.T) {
+	router := heligo.New()
+	router.Handle("GET", "/", heligo.Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush through the adapter: %v", err)
+		}
+	})))
+	var status int
+	router.Use(func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			status, _ = next(ctx, w, r)
+			return status, nil
+		}
+	})
+	router.Handle("GET", "/status", heligo.Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	rec := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	router.ServeHTTP(rec, r)
+	if rec.Code != http.StatusAccepted || !rec.Flushed {
+		t.Errorf("expected a flushed 202, got %d %v", rec.Code, rec.Flushed)
+	}
+	r, _ = http.NewRequest("GET", "/status", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if status != http.StatusTeapot {
+		t.Errorf("expected the adapted status 418, got %d", status)
+	}
+}
+
+func TestMiddlewareWriters(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware heligo.Middleware
+		method     string
+		calls      int // the calls of two requests, replayed by the cache and idempotency
+	}{
+		{"etag", heligo.ETag(heligo.ETagOptions{}), "GET", 2},
+		{"cache", heligo.Cache(heligo.NewResponseCache(heligo.CacheOptions{TTL: time.Minute})), "GET", 1},
+		{"idempotency", heligo.Idempotency(heligo.IdempotencyOptions{}), "POST", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := heligo.New()
+			router.Use(tt.middleware)
+			var calls int
+			router.Handle(tt.method, "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+				calls++
+				_, flusher := w.(http.Flusher)
+				_, hijacker := w.(http.Hijacker)
+				rf, readerFrom := w.(io.ReaderFrom)
+				if !flusher || !hijacker || !readerFrom {
+					t.Errorf("expected a Flusher, Hijacker and ReaderFrom, got %v %v %v", flusher, hijacker, readerFrom)
+					return http.StatusInternalServerError, nil
+				}
+				_, err := rf.ReadFrom(strings.NewReader("hello"))
+				return http.StatusOK, err
+			})
+
+			for range 2 {
+				rec := httptest.NewRecorder()
+				r := httptest.NewRequest(tt.method, "/", nil)
+				r.Header.Set("Idempotency-Key", "key")
+				router.ServeHTTP(rec, r)
+				if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+					t.Errorf("unexpected response %d %q", rec.Code, rec.Body)
+				}
+			}
+			if calls != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package heligo
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+// Route describes a registered route, as returned by Request.Route.
+type Route struct {
+	Method  string
+	Pattern string // the path pattern, including the group prefix
+	Host    string // the host pattern, empty for the default host
+	Name    string
+	// Meta holds the values attached with the Meta option.
+	// It must not be modified.
+	Meta map[string]any
+}
+
+// route is a handler registered with its options
+type route struct {
+	Route
+	handler  Handler
+	matchers []func(*http.Request) bool
+}
+
+func (rt *route) matches(r *http.Request) bool {
+	for _, m := range rt.matchers {
+		if !m(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// RouteOption configures a route when it is registered with Handle.
+//
+// Routes with matchers can share the same method and path: they are
+// tried in registration order and the first one whose matchers are all
+// satisfied is selected. A route without matchers for the same method
+// and path is used when none matches, otherwise the request falls
+// through to the not found response.
+type RouteOption func(*route)
+
+// Name sets the name of the route.
+func Name(name string) RouteOption {
+	return func(rt *route) {
+		rt.Name = name
+	}
+}
+
+// Meta attaches a value to the route, for middlewares to read it
+// with Request.Route, e.g. the required scopes or a rate-limit class.
+func Meta(key string, value any) RouteOption {
+	return func(rt *route) {
+		if rt.Meta == nil {
+			rt.Meta = map[string]any{}
+		}
+		rt.Meta[key] = value
+	}
+}
+
+// MatchFunc matches requests for which f returns true.
+func MatchFunc(f func(*http.Request) bool) RouteOption {
+	return func(rt *route) {
+		rt.matchers = append(rt.matchers, f)
+	}
+}
+
+// MatchHeader matches requests with a header key having the given value.
+func MatchHeader(key, value string) RouteOption {
+	key = http.CanonicalHeaderKey(key)
+	return MatchFunc(func(r *http.Request) bool {
+		return slices.Contains(r.Header[key], value)
+	})
+}
+
+// MatchHeaderRegexp matches requests with a header key having a value
+// matching the regular expression. It panics if the expression is invalid.
+func MatchHeaderRegexp(key, expr string) RouteOption {
+	key = http.CanonicalHeaderKey(key)
+	re := regexp.MustCompile(expr)
+	return MatchFunc(func(r *http.Request) bool {
+		for _, v := range r.Header[key] {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchQuery matches requests with the query parameter key.
+// If values are given, the parameter must be equal to one of them.
+func MatchQuery(key string, values ...string) RouteOption {
+	return MatchFunc(func(r *http.Request) bool {
+		q := r.URL.Query()
+		if len(values) == 0 {
+			return q.Has(key)
+		}
+		return slices.Contains(values, q.Get(key))
+	})
+}
+
+// MatchContentType matches requests whose media type, without parameters,
+// is one of the given ones.
+func MatchContentType(types ...string) RouteOption {
+	return MatchFunc(func(r *http.Request) bool {
+		ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return err == nil && slices.Contains(types, ct)
+	})
+}
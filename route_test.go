@@ -0,0 +1,108 @@
+package heligo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+func TestMatchers(t *testing.T) {
+	router := heligo.New()
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			return 200, nil
+		}
+	}
+	router.Handle("GET", "/items/:id", handler("v2"), heligo.MatchHeader("Accept", "application/vnd.x.v2+json"))
+	router.Handle("GET", "/items/:id", handler("v3"), heligo.MatchHeaderRegexp("Accept", `vnd\.x\.v3`))
+	router.Handle("GET", "/items/:id", handler("q2"), heligo.MatchQuery("api-version", "2", "2.1"))
+	router.Handle("GET", "/items/:id", handler("default"))
+	router.Handle("GET", "/items/:id", handler("debug"), heligo.MatchQuery("debug"))
+	router.Handle("POST", "/items", handler("json"), heligo.MatchContentType("application/json"))
+	router.Handle("POST", "/items", handler("form"), heligo.MatchContentType("application/x-www-form-urlencoded"))
+	router.Handle("PUT", "/items", handler("both"), heligo.MatchHeader("X-A", "1"), heligo.MatchFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.UserAgent(), "test")
+	}))
+
+	tests := []struct {
+		method  string
+		url     string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"GET", "/items/1", map[string]string{"Accept": "application/vnd.x.v2+json"}, 200, "v2"},
+		{"GET", "/items/1", map[string]string{"Accept": "application/vnd.x.v3+json"}, 200, "v3"},
+		{"GET", "/items/1?api-version=2.1", nil, 200, "q2"},
+		{"GET", "/items/1?api-version=3", nil, 200, "default"},
+		{"GET", "/items/1?debug", nil, 200, "debug"},
+		{"GET", "/items/1?debug&api-version=2", nil, 200, "q2"},
+		{"GET", "/items/1", nil, 200, "default"},
+		{"POST", "/items", map[string]string{"Content-Type": "application/json; charset=utf-8"}, 200, "json"},
+		{"POST", "/items", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, 200, "form"},
+		{"POST", "/items", map[string]string{"Content-Type": "text/plain"}, 404, ""},
+		{"PUT", "/items", map[string]string{"X-A": "1", "User-Agent": "test/1"}, 200, "both"},
+		{"PUT", "/items", map[string]string{"X-A": "1"}, 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.url, nil)
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s %v: expected %d, got %d", test.method, test.url, test.headers, test.status, w.Code)
+			continue
+		}
+		if test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("%s %s %v: expected %q, got %q", test.method, test.url, test.headers, test.body, w.Body.String())
+		}
+	}
+	if !router.HasPath("GET", "/items") {
+		t.Error("expected HasPath for routes with matchers")
+	}
+}
+
+func TestRouteInfo(t *testing.T) {
+	router := heligo.New()
+	router.Use(func(next heligo.Handler) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			rt := r.Route()
+			w.Header().Set("X-Route", rt.Method+" "+rt.Host+rt.Pattern+" "+rt.Name)
+			if scope, ok := rt.Meta["scope"].(string); ok {
+				w.Header().Set("X-Scope", scope)
+			}
+			return next(ctx, w, r)
+		}
+	})
+	h := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	}
+	router.Group("/api").Handle("GET", "/users/:id?", h, heligo.Name("getUser"), heligo.Meta("scope", "users:read"))
+	router.Host("admin.example.com").Handle("POST", "/users", h)
+
+	tests := []struct {
+		method, url, route, scope string
+	}{
+		{"GET", "/api/users/1", "GET /api/users/:id? getUser", "users:read"},
+		{"GET", "/api/users", "GET /api/users/:id? getUser", "users:read"},
+		{"POST", "http://admin.example.com/users", "POST admin.example.com/users ", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.url, nil)
+		router.ServeHTTP(w, r)
+		if got := w.Header().Get("X-Route"); got != test.route {
+			t.Errorf("%s %s: expected route %q, got %q", test.method, test.url, test.route, got)
+		}
+		if got := w.Header().Get("X-Scope"); got != test.scope {
+			t.Errorf("%s %s: expected scope %q, got %q", test.method, test.url, test.scope, got)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package heligo
 
 import (
+	"log"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -11,22 +17,57 @@ const (
 )
 
 type Router struct {
-	get           *node
-	trees         map[string]*node
+	table         atomic.Pointer[table]
+	mu            sync.Mutex
 	middlewares   []Middleware
 	ErrorHandler  func(http.ResponseWriter, *http.Request, int, error)
 	TrailingSlash bool
+	// MaxPathLength, if positive, is the maximum length of the routed path,
+	// escaped with UseRawPath: longer paths are answered with 414 URI Too Long
+	MaxPathLength int
+	// RejectEmptyParams makes :params not match empty segments, as in
+	// "/users//posts": the lookup falls through to other routes or to 404.
+	// Wildcard *params are not affected.
+	RejectEmptyParams bool
+	// UseRawPath routes on the escaped path, so that an escaped slash (%2F)
+	// doesn't split a segment. Request.Param unescapes the values.
+	UseRawPath bool
+	// StrictStatus compares the status returned by the handlers, after the
+	// ErrorHandler, with the status written. A status returned without
+	// writing the header is written, mismatches are reported to
+	// OnStatusMismatch, or logged if nil. Meant for development and tests.
+	StrictStatus     bool
+	OnStatusMismatch func(r *http.Request, returned int, written int)
+}
+
+// table is an immutable snapshot of the registered routes.
+// Writers build a new table sharing the unchanged nodes and swap it,
+// so that lookups are safe while routes are added or removed.
+type table struct {
+	routes
+	hosts []*routes
+}
+
+// routes holds the method trees for the default host or for a host pattern
+type routes struct {
+	get        *node
+	trees      map[string]*node
+	host       string
+	hostParams []uint16
 }
 
 type Group struct {
 	router      *Router
+	host        string
 	path        string
 	middlewares []Middleware
 }
 
 // New creates a new router
 func New() *Router {
-	return &Router{trees: make(map[string]*node)}
+	router := &Router{}
+	router.table.Store(&table{routes: routes{trees: make(map[string]*node)}})
+	return router
 }
 
 // Use registers a global middleware.
@@ -37,18 +78,127 @@ func (router *Router) Use(middlewares ...Middleware) {
 
 // Group creates a new group of handlers, with common middlewares
 func (router *Router) Group(path string, middlewares ...Middleware) *Group {
-	return &Group{router, path, middlewares}
+	return &Group{router, "", path, middlewares}
+}
+
+// Host creates a new group of handlers matched only for requests
+// whose host matches the pattern, e.g. "api.example.com" or ":tenant.example.com".
+// Labels starting with ':' are parameters, accessible with Request.Param.
+// Literal labels are compared case-insensitively and the port is ignored.
+// Host groups are tried in registration order, before the routes
+// registered without a host.
+func (router *Router) Host(pattern string, middlewares ...Middleware) *Group {
+	if len(parseHost(pattern)) > MAXPARAMS {
+		panic("heligo: too many parameters in host " + pattern)
+	}
+	router.update(func(t *table) bool {
+		t.routesFor(pattern)
+		return true
+	})
+	return &Group{router, pattern, "", middlewares}
+}
+
+// update applies f to a copy of the current table and, if f reports
+// a change, makes it the current one.
+func (router *Router) update(f func(t *table) bool) bool {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	t := *router.table.Load()
+	t.hosts = slices.Clone(t.hosts)
+	if !f(&t) {
+		return false
+	}
+	router.table.Store(&t)
+	return true
+}
+
+// routesFor returns a copy of the routes for the host pattern,
+// replacing the original in the table. Missing hosts are created.
+func (t *table) routesFor(host string) *routes {
+	var rs *routes
+	if host == "" {
+		rs = &t.routes
+	} else {
+		i := slices.IndexFunc(t.hosts, func(h *routes) bool { return h.host == host })
+		if i < 0 {
+			t.hosts = append(t.hosts, &routes{host: host, hostParams: parseHost(host)})
+			i = len(t.hosts) - 1
+		}
+		h := *t.hosts[i]
+		t.hosts[i] = &h
+		rs = &h
+	}
+	rs.trees = maps.Clone(rs.trees)
+	if rs.trees == nil {
+		rs.trees = make(map[string]*node)
+	}
+	return rs
 }
 
 // Handle registers a new handler for method and path.
 // If TrailingSlash is true, both "/path" and "/path/" will match.
-func (router *Router) Handle(method string, path string, handler Handler) {
-	handler = chain(handler, router.middlewares)
-	router.addRoute(method, path, handler)
+// Options can add matchers on headers, query or custom predicates: see RouteOption.
+// Handle can be called while the router is serving requests.
+func (router *Router) Handle(method string, path string, handler Handler, options ...RouteOption) {
+	router.handle("", method, path, handler, options)
+}
+
+// Remove unregisters the handlers for method and path, including the
+// ones with matchers and the trailing slash variant if TrailingSlash is true.
+// It reports whether a handler was found.
+// Remove can be called while the router is serving requests.
+func (router *Router) Remove(method string, path string) bool {
+	return router.remove("", method, path)
+}
+
+func (router *Router) handle(host string, method string, path string, handler Handler, options []RouteOption) {
+	if countParams(path)+len(parseHost(host)) > MAXPARAMS {
+		panic("heligo: too many parameters in path " + path)
+	}
+	rt := &route{Route: Route{Method: method, Pattern: path, Host: host}}
+	for _, opt := range options {
+		opt(rt)
+	}
+	rt.handler = chain(handler, router.middlewares)
+	router.update(func(t *table) bool {
+		rs := t.routesFor(host)
+		for _, p := range router.variants(path) {
+			rs.addRoute(method, p, rt)
+		}
+		return true
+	})
+}
 
-	if router.TrailingSlash && len(path) > 1 {
+func (router *Router) remove(host string, method string, path string) bool {
+	return router.update(func(t *table) bool {
+		rs := t.routesFor(host)
+		removed := false
+		for _, p := range router.variants(path) {
+			if rs.removeRoute(method, p) {
+				removed = true
+			}
+		}
+		return removed
+	})
+}
+
+// variants returns the paths to register for path.
+// An optional last segment, as in "/posts/:id?", adds the path without it.
+// If TrailingSlash is true, it includes the variant with or without the final slash.
+func (router *Router) variants(path string) []string {
+	paths := []string{path}
+	if base, ok := optionalBase(path); ok {
+		paths = []string{path[:len(path)-1], base}
+	}
+	if !router.TrailingSlash {
+		return paths
+	}
+	for _, path := range paths {
+		if len(path) <= 1 {
+			continue
+		}
 		if path[len(path)-1] == SLASH {
-			router.addRoute(method, path[:len(path)-1], handler)
+			paths = append(paths, path[:len(path)-1])
 		} else {
 			// skip paths ending with a wildcard param
 			lastSlash := len(path) - 1
@@ -56,90 +206,222 @@ func (router *Router) Handle(method string, path string, handler Handler) {
 				lastSlash--
 			}
 			if lastSlash < len(path)-1 && path[lastSlash+1] != STAR {
-				router.addRoute(method, path+"/", handler)
+				paths = append(paths, path+"/")
 			}
 		}
 	}
+	return paths
 }
 
-func (router *Router) addRoute(method string, path string, handler Handler) {
-	var n *node
-	if method[0] == 'G' {
-		n = router.get
-		if n == nil {
-			n = &node{}
-			router.get = n
-		}
+func (rs *routes) addRoute(method string, path string, rt *route) {
+	n := rs.leaf(method, path)
+	if len(rt.matchers) == 0 {
+		n.route = rt
 	} else {
-		n = router.trees[method]
-		if n == nil {
-			n = &node{}
-			router.trees[method] = n
+		n.routes = append(n.routes, rt)
+	}
+}
+
+// removeRoute removes the handlers for path, pruning the nodes left
+// without routes. The nodes from the root to the leaf are copies, as in leaf.
+func (rs *routes) removeRoute(method string, path string) bool {
+	branch := rs.branch(method, path)
+	if branch == nil || !branch[len(branch)-1].hasHandler() {
+		return false
+	}
+	for i, n := range branch {
+		c := n.clone()
+		if i == 0 {
+			rs.setTree(method, c)
+		} else {
+			branch[i-1].replaceChild(n, c)
 		}
+		branch[i] = c
 	}
+	leaf := branch[len(branch)-1]
+	leaf.route = nil
+	leaf.routes = nil
+	k := countParams(path)
+	for i := len(branch) - 1; i > 0; i-- {
+		if c := branch[i]; c.text == string(COLON) || c.text == string(STAR) {
+			k--
+		}
+		branch[i-1].prune(branch[i], k)
+	}
+	if root := branch[0]; root.isEmpty() {
+		rs.setTree(method, nil)
+	}
+	return true
+}
 
-	var startParam int = -1
-	var idxPath int
-	for i := 0; i < len(path); i++ {
-		if startParam == -1 {
-			if path[i] == COLON || path[i] == STAR {
-				startParam = i + 1
-				n = n.nextNode(path[idxPath:i])
-				n = n.nextNode(path[i : i+1])
+// branch returns the nodes from the root to the node for path,
+// or nil if path is not in the tree. The nodes are not copied.
+func (rs *routes) branch(method string, path string) []*node {
+	var n *node
+	if method[0] == 'G' {
+		n = rs.get
+	} else {
+		n = rs.trees[method]
+	}
+	if n == nil {
+		return nil
+	}
+	branch := []*node{n}
+	for i := 0; i < len(path); {
+		var next *node
+		switch path[i] {
+		case STAR:
+			next, i = n.childStar, len(path)
+		case COLON:
+			_, expr, end := parseParam(path, i)
+			next, i = n.childColon, end
+			if expr != "" {
+				expr = "^(?:" + expr + ")$"
+				next = nil
+				for _, child := range n.childRe {
+					if child.re.String() == expr {
+						next = child
+					}
+				}
 			}
-		} else {
-			// scanning the param
-			if path[i] == SLASH {
-				n.param = path[startParam:i]
-				startParam = -1
-				idxPath = i
+		default:
+			for _, child := range n.children {
+				if strings.HasPrefix(path[i:], child.text) {
+					next, i = child, i+len(child.text)
+					break
+				}
 			}
 		}
+		if next == nil {
+			return nil
+		}
+		n = next
+		branch = append(branch, n)
 	}
-	if startParam != -1 {
-		n.param = path[startParam:]
-	} else if idxPath <= len(path)-1 {
-		n = n.nextNode(path[idxPath:])
+	return branch
+}
+
+// setTree replaces the tree for method, removing it if n is nil
+func (rs *routes) setTree(method string, n *node) {
+	switch {
+	case method[0] == 'G':
+		rs.get = n
+	case n == nil:
+		delete(rs.trees, method)
+	default:
+		rs.trees[method] = n
 	}
-	n.handler = handler
 }
 
-func (router *Router) getHandler(method string, path string, p *params) Handler {
+// leaf returns the node for path, creating it if missing.
+// The nodes from the root to the leaf are copies, that can be modified
+// without affecting the previous trees.
+func (rs *routes) leaf(method string, path string) *node {
 	var n *node
 	if method[0] == 'G' {
-		n = router.get
+		n = rs.get.clone()
+		rs.get = n
 	} else {
-		n = router.trees[method]
-		if n == nil && method[0] == 'H' {
-			n = router.get
+		n = rs.trees[method].clone()
+		rs.trees[method] = n
+	}
+
+	var idxPath int
+	for i := 0; i < len(path); {
+		if path[i] != COLON && path[i] != STAR {
+			i++
+			continue
+		}
+		if i == idxPath {
+			panic("heligo: parameters must be separated by static text in path " + path)
 		}
+		n = n.nextNode(path[idxPath:i])
+		if path[i] == STAR {
+			// a wildcard takes the rest of the path
+			n = n.nextNode(path[i : i+1])
+			n.param = internName(path[i+1:])
+			return n
+		}
+		name, expr, end := parseParam(path, i)
+		n = n.paramNode(expr)
+		n.param = internName(name)
+		if end < len(path) && path[end] != SLASH {
+			n.suffix = true
+		}
+		i, idxPath = end, end
+	}
+	if idxPath <= len(path)-1 {
+		n = n.nextNode(path[idxPath:])
 	}
+	return n
+}
+
+// tree returns the tree for method, HEAD using GET if not registered
+func (rs *routes) tree(method string) *node {
+	if method[0] == 'G' {
+		return rs.get
+	}
+	n := rs.trees[method]
+	if n == nil && method[0] == 'H' {
+		n = rs.get
+	}
+	return n
+}
+
+func (rs *routes) getRoute(r *http.Request, path string, offset int, p *params) *route {
+	n := rs.tree(r.Method)
 	if n == nil {
 		return nil
 	}
-	n = n.findNode(path, 0, p)
-	if n != nil {
-		return n.handler
+	if n = n.findNode(path[offset:], offset, p); n == nil {
+		return nil
+	}
+	if len(n.routes) == 0 {
+		return n.route
+	}
+	return n.match(r)
+}
+
+// getRoute finds the route for path, starting at offset.
+// A non zero offset means the router is mounted: its host groups are ignored.
+func (router *Router) getRoute(r *http.Request, path string, offset int, p *params) *route {
+	t := router.table.Load()
+	if offset == 0 && len(t.hosts) > 0 {
+		return t.getHostRoute(r, path, p)
+	}
+	return t.routes.getRoute(r, path, offset, p)
+}
+
+// getHostRoute finds the route for path in the host groups matching r,
+// and then in the default routes
+func (t *table) getHostRoute(r *http.Request, path string, p *params) *route {
+	for _, h := range t.hosts {
+		if matchHost(h, r.Host, p) {
+			if rt := h.getRoute(r, path, 0, p); rt != nil {
+				return rt
+			}
+		}
+		p.count, p.hostCount = 0, 0
 	}
-	return nil
+	return t.routes.getRoute(r, path, 0, p)
 }
 
 // hasPath checks if the path is registered under any method other than the given one
-func (router *Router) hasPath(method string, path string) bool {
-	var p params
+func (rs *routes) hasPath(method string, path string, nonEmpty bool) bool {
+	p := params{nonEmpty: nonEmpty}
 	if method != http.MethodGet {
-		if router.get != nil {
-			if n := router.get.findNode(path, 0, &p); n != nil && n.handler != nil {
+		if rs.get != nil {
+			if n := rs.get.findNode(path, 0, &p); n != nil && n.hasHandler() {
 				return true
 			}
 		}
 	}
-	for m, tree := range router.trees {
+	for m, tree := range rs.trees {
 		if m == method {
 			continue
 		}
-		p = params{}
-		if n := tree.findNode(path, 0, &p); n != nil && n.handler != nil {
+		p = params{nonEmpty: nonEmpty}
+		if n := tree.findNode(path, 0, &p); n != nil && n.hasHandler() {
 			return true
 		}
 	}
@@ -148,10 +430,55 @@ func (router *Router) hasPath(method string, path string) bool {
 
 // ServeHTTP complies with the standard http.Handler interface
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	req := Request{Request: r}
-	handler := router.getHandler(r.Method, r.URL.Path, &req.params)
-	if handler != nil {
-		status, err := handler(r.Context(), w, req)
+	t := router.table.Load()
+	if len(t.hosts) > 0 || router.MaxPathLength > 0 || router.UseRawPath || router.RejectEmptyParams || router.StrictStatus {
+		router.serve(w, r)
+		return
+	}
+	// the plain lookup, without options and host groups
+	req := Request{Request: r, path: r.URL.Path}
+	n := t.routes.tree(r.Method)
+	if n != nil {
+		n = n.findNode(req.path, 0, &req.params)
+	}
+	if n == nil || !n.hasHandler() {
+		http.NotFound(w, r)
+		return
+	}
+	rt := n.route
+	if len(n.routes) > 0 {
+		if rt = n.match(r); rt == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	req.route = rt
+	status, err := rt.handler(r.Context(), w, req)
+	if err != nil && router.ErrorHandler != nil {
+		router.ErrorHandler(w, r, status, err)
+	}
+}
+
+// serve serves r with the options and the host groups
+func (router *Router) serve(w http.ResponseWriter, r *http.Request) {
+	req := Request{Request: r, path: r.URL.Path}
+	if router.UseRawPath {
+		req.path = r.URL.EscapedPath()
+		req.params.escaped = true
+	}
+	if router.MaxPathLength > 0 && len(req.path) > router.MaxPathLength {
+		http.Error(w, "URI Too Long", http.StatusRequestURITooLong)
+		return
+	}
+	req.params.nonEmpty = router.RejectEmptyParams
+	rt := router.getRoute(r, req.path, 0, &req.params)
+	if rt != nil {
+		req.route = rt
+		if router.StrictStatus {
+			router.serveStrict(w, req, rt)
+			return
+		}
+		status, err := rt.handler(r.Context(), w, req)
 		if err != nil && router.ErrorHandler != nil {
 			router.ErrorHandler(w, r, status, err)
 		}
@@ -160,21 +487,75 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveStrict serves req reconciling the returned and the written status
+func (router *Router) serveStrict(w http.ResponseWriter, req Request, rt *route) {
+	rw := NewResponseWriter(w)
+	status, err := rt.handler(req.Context(), rw, req)
+	if err != nil && router.ErrorHandler != nil {
+		router.ErrorHandler(rw, req.Request, status, err)
+	}
+	if rw.hijacked {
+		return
+	}
+	if !rw.Written() && status >= 200 && status <= 999 {
+		rw.WriteHeader(status)
+		return
+	}
+	if written := rw.Status(); written != status {
+		if router.OnStatusMismatch != nil {
+			router.OnStatusMismatch(req.Request, status, written)
+		} else {
+			log.Printf("heligo: %s %s: returned status %d, written %d", req.Method, req.URL.Path, status, written)
+		}
+	}
+}
+
 // HasPath reports whether the given path is registered under any method
 // other than the one specified. Useful for implementing 405 responses.
+// Host groups are included in the check, regardless of the request host.
 func (router *Router) HasPath(method string, path string) bool {
-	return router.hasPath(method, path)
+	t := router.table.Load()
+	if t.routes.hasPath(method, path, router.RejectEmptyParams) {
+		return true
+	}
+	for _, h := range t.hosts {
+		if h.hasPath(method, path, router.RejectEmptyParams) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns the registered routes, first for the default host and
+// then for the host groups. Within a host, GET routes come first and the
+// other methods are sorted, in precedence order.
+func (router *Router) Routes() []Route {
+	t := router.table.Load()
+	var list []Route
+	for _, rs := range append([]*routes{&t.routes}, t.hosts...) {
+		for _, tree := range rs.methodTrees(http.MethodGet) {
+			tree.n.walk(func(rt *route) {
+				list = append(list, rt.Route)
+			})
+		}
+	}
+	return list
 }
 
 // Group creates a new sub-group of handlers, with common middlewares
 func (g *Group) Group(path string, middlewares ...Middleware) *Group {
 	mw := make([]Middleware, len(g.middlewares), len(g.middlewares)+len(middlewares))
 	copy(mw, g.middlewares)
-	return &Group{g.router, g.path + path, append(mw, middlewares...)}
+	return &Group{g.router, g.host, g.path + path, append(mw, middlewares...)}
 }
 
 // Handle registers a new handler under a group for method and path.
-func (g *Group) Handle(method string, path string, handler Handler) {
+func (g *Group) Handle(method string, path string, handler Handler, options ...RouteOption) {
 	handler = chain(handler, g.middlewares)
-	g.router.Handle(method, g.path+path, handler)
+	g.router.handle(g.host, method, g.path+path, handler, options)
+}
+
+// Remove unregisters the handlers under a group for method and path.
+func (g *Group) Remove(method string, path string) bool {
+	return g.router.remove(g.host, method, g.path+path)
 }
@@ -2,9 +2,12 @@ package heligo_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/sted/heligo"
@@ -232,3 +235,277 @@ func BenchmarkRouter(b *testing.B) {
 		}
 	})
 }
+
+func TestHost(t *testing.T) {
+	router := heligo.New()
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			for _, p := range r.Params() {
+				w.Write([]byte(" " + p.Name + "=" + p.Value))
+			}
+			return 200, nil
+		}
+	}
+	router.Host("api.example.com").Handle("GET", "/users/:id", handler("api"))
+	tenants := router.Host(":tenant.example.com")
+	tenants.Handle("GET", "/", handler("tenant"))
+	tenants.Group("/projects").Handle("GET", "/:id", handler("tenant"))
+	router.Host(":tenant.:region.example.com").Handle("GET", "/", handler("region"))
+	router.Handle("GET", "/", handler("default"))
+	router.Handle("GET", "/users/:id", handler("default"))
+
+	tests := []struct {
+		host   string
+		url    string
+		status int
+		body   string
+	}{
+		{"api.example.com", "/users/1", 200, "api id=1"},
+		{"API.Example.com:8080", "/users/1", 200, "api id=1"},
+		{"acme.example.com", "/", 200, "tenant tenant=acme"},
+		{"acme.example.com.", "/projects/7", 200, "tenant tenant=acme id=7"},
+		{"acme.eu.example.com", "/", 200, "region tenant=acme region=eu"},
+		// host groups are tried in order, then the default routes
+		{"api.example.com", "/", 200, "tenant tenant=api"},
+		{"acme.example.com", "/users/2", 200, "default id=2"},
+		{"example.com", "/", 200, "default"},
+		{"other.org", "/projects/7", 404, ""},
+		{"example.com", "/projects/7", 404, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		r.Host = test.host
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s%s: expected %d, got %d", test.host, test.url, test.status, w.Code)
+			continue
+		}
+		if test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("%s%s: expected %q, got %q", test.host, test.url, test.body, w.Body.String())
+		}
+	}
+}
+
+func TestWildcardEmpty(t *testing.T) {
+	router := heligo.New()
+	var got []heligo.Param
+	handler := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		got = r.Params()
+		return 200, nil
+	}
+	router.Handle("GET", "/src/*filepath", handler)
+	router.Handle("GET", "/dst/", handler)
+	router.Handle("GET", "/dst/*filepath", handler)
+
+	tests := []struct {
+		url    string
+		status int
+		params int
+	}{
+		{"/src/", 404, 0},
+		{"/src/a", 200, 1},
+		{"/src", 404, 0},
+		{"/dst/", 200, 0},
+		{"/dst/a", 200, 1},
+	}
+	for _, test := range tests {
+		got = nil
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.url, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status || len(got) != test.params {
+			t.Errorf("%s: expected %d with %d params, got %d %v", test.url, test.status, test.params, w.Code, got)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	router := heligo.New()
+	router.TrailingSlash = true
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			return 200, nil
+		}
+	}
+	router.Handle("GET", "/users/:id", handler("param"))
+	router.Handle("GET", "/users/me", handler("static"))
+	router.Handle("GET", "/users/me", handler("json"), heligo.MatchHeader("Accept", "application/json"))
+	router.Handle("GET", "/files/*path", handler("files"))
+	plugins := router.Group("/plugins")
+	plugins.Handle("POST", "/hook", handler("hook"))
+
+	check := func(url, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, r)
+		if body == "" && w.Code != 404 || body != "" && w.Body.String() != body {
+			t.Errorf("%s: expected %q, got %d %q", url, body, w.Code, w.Body.String())
+		}
+	}
+	check("/users/me", "static")
+	if !router.Remove("GET", "/users/me") {
+		t.Error("expected /users/me to be removed")
+	}
+	if router.Remove("GET", "/users/me") || router.Remove("GET", "/users/you") || router.Remove("PUT", "/users/:id") {
+		t.Error("expected no removal")
+	}
+	// the static route no longer shadows the param one
+	check("/users/me", "param")
+	check("/users/me/", "param")
+	router.Remove("GET", "/users/:id")
+	check("/users/me", "")
+	check("/users/42/", "")
+	router.Remove("GET", "/files/*path")
+	check("/files/a", "")
+
+	if !router.HasPath("GET", "/plugins/hook") || !plugins.Remove("POST", "/hook") || router.HasPath("GET", "/plugins/hook") {
+		t.Error("expected /plugins/hook to be removed")
+	}
+}
+
+func TestRemoveReset(t *testing.T) {
+	router := heligo.New()
+	handler := func(name string) heligo.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+			w.Write([]byte(name))
+			for _, p := range r.Params() {
+				w.Write([]byte(" " + p.Name + "=" + p.Value))
+			}
+			return 200, nil
+		}
+	}
+	check := func(url, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, r)
+		if body == "" && w.Code != 404 || body != "" && w.Body.String() != body {
+			t.Errorf("%s: expected %q, got %d %q", url, body, w.Code, w.Body.String())
+		}
+	}
+
+	// the constraint goes with the route
+	for range 3 {
+		router.Handle("GET", "/b/:id{[0-9]+}", handler("number"))
+		check("/b/42", "number id=42")
+		router.Remove("GET", "/b/:id{[0-9]+}")
+		router.Handle("GET", "/b/:id{[a-z]+}", handler("letters"))
+		check("/b/42", "")
+		check("/b/abc", "letters id=abc")
+		router.Remove("GET", "/b/:id{[a-z]+}")
+		check("/b/abc", "")
+	}
+
+	// the name of a shared param goes back to the remaining route
+	router.Handle("GET", "/p/:id/x", handler("x"))
+	router.Handle("GET", "/p/:name.txt", handler("text"))
+	router.Handle("GET", "/p/:name", handler("name"))
+	router.Remove("GET", "/p/:name")
+	router.Remove("GET", "/p/:name.txt")
+	check("/p/1/x", "x id=1")
+	check("/p/a.txt", "")
+
+	// static nodes split by a removed route are merged again
+	router.Handle("GET", "/static/users", handler("users"))
+	router.Handle("GET", "/static/use", handler("use"))
+	router.Remove("GET", "/static/use")
+	router.Handle("GET", "/static/:name", handler("param"))
+	check("/static/use", "param name=use")
+	check("/static/users", "users")
+	if routes := router.Routes(); len(routes) != 3 {
+		t.Errorf("expected 3 routes, got %v", routes)
+	}
+}
+
+func TestConcurrentHandle(t *testing.T) {
+	router := heligo.New()
+	handler := func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return 200, nil
+	}
+	router.Handle("GET", "/stable/:id", handler)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			path := "/dynamic/" + strconv.Itoa(i%10) + "/:id"
+			router.Handle("GET", path, handler)
+			router.Host(":tenant.example.com").Handle("GET", path, handler)
+			router.Remove("GET", path)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/stable/1", nil)
+		r.Host = "acme.example.com"
+		router.ServeHTTP(w, r)
+		if w.Code != 200 {
+			t.Fatalf("expected 200 while registering, got %d", w.Code)
+		}
+	}
+}
+
+func TestStrictStatus(t *testing.T) {
+	type mismatch struct{ returned, written int }
+	var got []mismatch
+	router := heligo.New()
+	router.StrictStatus = true
+	router.OnStatusMismatch = func(r *http.Request, returned, written int) {
+		got = append(got, mismatch{returned, written})
+	}
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		if r.URL.Path == "/error" {
+			http.Error(w, err.Error(), status)
+		}
+	}
+	handle := func(path string, h heligo.Handler) {
+		router.Handle("GET", path, h)
+	}
+	handle("/ok", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return heligo.WriteJSON(w, http.StatusCreated, "ok")
+	})
+	handle("/implicit", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return http.StatusNoContent, nil
+	})
+	handle("/mismatch", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		w.Write([]byte("body"))
+		return http.StatusCreated, nil
+	})
+	handle("/error", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return http.StatusConflict, errors.New("conflict")
+	})
+	handle("/adapted", heligo.Adapt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		path     string
+		status   int
+		mismatch []mismatch
+	}{
+		{"/ok", http.StatusCreated, nil},
+		{"/implicit", http.StatusNoContent, nil},
+		{"/mismatch", http.StatusOK, []mismatch{{http.StatusCreated, http.StatusOK}}},
+		{"/error", http.StatusConflict, nil},
+		{"/adapted", http.StatusOK, nil},
+	}
+	for _, test := range tests {
+		got = nil
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.path, test.status, w.Code)
+		}
+		if !reflect.DeepEqual(got, test.mismatch) {
+			t.Errorf("%s: expected mismatches %v, got %v", test.path, test.mismatch, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package heligo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed is returned when sending on a closed EventStream
+var ErrStreamClosed = errors.New("heligo: event stream closed")
+
+// EventStream sends Server-Sent Events, see SSE.
+// Its methods can be called concurrently.
+type EventStream struct {
+	ctx         context.Context
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	lastEventID string
+	mu          sync.Mutex
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// SSE starts a Server-Sent Events response, writing the header.
+// The stream ends when ctx is cancelled: Send then returns the context error,
+// and the handler should return. It returns an error if w can't flush.
+//
+//	stream, err := heligo.SSE(ctx, w, r)
+//	if err != nil {
+//		return http.StatusInternalServerError, err
+//	}
+//	defer stream.Close()
+//	stream.Heartbeat(15 * time.Second)
+//	for {
+//		select {
+//		case <-ctx.Done():
+//			return http.StatusOK, nil
+//		case u := <-updates:
+//			if err := stream.Send("update", u.ID, u.Data); err != nil {
+//				return http.StatusOK, nil
+//			}
+//		}
+//	}
+func SSE(ctx context.Context, w http.ResponseWriter, r Request) (*EventStream, error) {
+	s := &EventStream{
+		ctx:         ctx,
+		w:           w,
+		rc:          http.NewResponseController(w),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+		stop:        make(chan struct{}),
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// disable the buffering of proxies like nginx
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return nil, fmt.Errorf("heligo: streaming not supported: %w", err)
+	}
+	return s, nil
+}
+
+// LastEventID returns the id of the last event received by the client,
+// sent on reconnection to resume the stream, empty if none.
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send sends an event with data, that can span multiple lines.
+// event and id are omitted if empty.
+func (s *EventStream) Send(event string, id string, data string) error {
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + singleLine(event) + "\n")
+	}
+	if id != "" {
+		b.WriteString("id: " + singleLine(id) + "\n")
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// SendJSON sends an event with v encoded as JSON.
+func (s *EventStream) SendJSON(event string, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(event, id, string(data))
+}
+
+// Retry tells the client how long to wait before reconnecting.
+func (s *EventStream) Retry(d time.Duration) error {
+	return s.write("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n")
+}
+
+// Comment sends a comment, ignored by the clients.
+func (s *EventStream) Comment(text string) error {
+	return s.write(": " + singleLine(text) + "\n\n")
+}
+
+// Heartbeat sends a comment every interval until the stream is closed or
+// ctx is cancelled, to keep the connection open through proxies.
+func (s *EventStream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if s.Comment("heartbeat") != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat and the sending of events. The response
+// ends when the handler returns.
+func (s *EventStream) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	// wait for a heartbeat being written
+	s.mu.Lock()
+	s.mu.Unlock()
+}
+
+// write writes and flushes msg, unless the stream is done
+func (s *EventStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-s.stop:
+		return ErrStreamClosed
+	default:
+	}
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// singleLine replaces the line breaks, not allowed in fields
+func singleLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,82 @@
+package heligo_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+func TestSSE(t *testing.T) {
+	router := heligo.New()
+	router.Handle("GET", "/events", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		stream, err := heligo.SSE(ctx, w, r)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		defer stream.Close()
+		stream.Retry(3 * time.Second)
+		stream.Send("resume", "", stream.LastEventID())
+		stream.Send("update", "2", "line 1\nline 2")
+		stream.SendJSON("", "3\n", map[string]int{"n": 3})
+		stream.Heartbeat(time.Millisecond)
+		<-ctx.Done()
+		if err := stream.Send("late", "", ""); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the context error, got %v", err)
+		}
+		return http.StatusOK, nil
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	want := "retry: 3000\n\n" +
+		"event: resume\ndata: 1\n\n" +
+		"event: update\nid: 2\ndata: line 1\ndata: line 2\n\n" +
+		"id: 3 \ndata: {\"n\":3}\n\n" +
+		": heartbeat\n\n"
+	var got strings.Builder
+	reader := bufio.NewReader(resp.Body)
+	for got.Len() < len(want) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading %q: %v", got.String(), err)
+		}
+		got.WriteString(line)
+	}
+	if got.String() != want {
+		t.Errorf("expected\n%q\ngot\n%q", want, got.String())
+	}
+	cancel()
+}
+
+func TestSSEClosed(t *testing.T) {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	stream, err := heligo.SSE(context.Background(), w, heligo.Request{Request: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if err := stream.Comment("x"); !errors.Is(err, heligo.ErrStreamClosed) {
+		t.Errorf("expected ErrStreamClosed, got %v", err)
+	}
+}
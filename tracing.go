@@ -0,0 +1,329 @@
+package heligo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTraceparent is returned when parsing an invalid traceparent header
+var ErrTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// MarshalText encodes the id in hexadecimal
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// SpanID identifies a span in a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// MarshalText encodes the id in hexadecimal
+func (id SpanID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// SpanContext is the part of a span propagated across services,
+// as in the W3C Trace Context headers.
+type SpanContext struct {
+	TraceID TraceID `json:"traceId"`
+	SpanID  SpanID  `json:"spanId"`
+	Flags   byte    `json:"flags"`
+	// State is the tracestate header, propagated unchanged
+	State string `json:"state,omitempty"`
+}
+
+// IsValid reports whether sc has valid trace and span ids
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Sampled reports whether the sampled flag is set
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// Traceparent returns the traceparent header value for sc
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent parses a traceparent header value.
+// Future versions are accepted, ignoring the extra fields.
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+	h = strings.TrimSpace(h)
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return sc, ErrTraceparent
+	}
+	version, err := hex.DecodeString(h[:2])
+	if err != nil || version[0] == 0xff || version[0] == 0 && len(h) != 55 || len(h) > 55 && h[55] != '-' {
+		return sc, ErrTraceparent
+	}
+	if !isLowerHex(h[:55]) {
+		return sc, ErrTraceparent
+	}
+	hex.Decode(sc.TraceID[:], []byte(h[3:35]))
+	hex.Decode(sc.SpanID[:], []byte(h[36:52]))
+	flags, _ := hex.DecodeString(h[53:55])
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrTraceparent
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c != '-' && !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Span is a timed operation in a trace.
+type Span struct {
+	Name       string         `json:"name"`
+	Context    SpanContext    `json:"context"`
+	Parent     SpanContext    `json:"parent,omitzero"` // invalid for root spans
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Status     int            `json:"status,omitempty"` // the HTTP status of server spans
+	Error      string         `json:"error,omitempty"`
+
+	mu       sync.Mutex
+	exporter SpanExporter
+	onError  func(error)
+	ended    bool
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]any{}
+	}
+	s.Attributes[key] = value
+}
+
+// SetError records err as the error of the span.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span, exporting it if sampled.
+// Calls after the first are ignored.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.exporter != nil && s.Context.Sampled() {
+		if err := s.exporter.ExportSpan(s); err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	}
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the current span, nil if none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// StartSpan starts a child of the current span, to be ended with Finish.
+// Without a current span the returned span is not exported.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{Name: name, Start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.Parent = parent.Context
+		s.Context = parent.Context
+		s.exporter, s.onError = parent.exporter, parent.onError
+	} else {
+		s.Context.TraceID = newTraceID()
+	}
+	s.Context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers for the
+// current span in h, to propagate the trace to outgoing requests.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set("Traceparent", s.Context.Traceparent())
+	if s.Context.State != "" {
+		h.Set("Tracestate", s.Context.State)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
+
+// SpanExporter receives the ended spans. It must be safe for concurrent use.
+// Implementations can bridge to OpenTelemetry or other tracing systems.
+type SpanExporter interface {
+	ExportSpan(s *Span) error
+}
+
+// TracingOptions configures the Tracing middleware.
+type TracingOptions struct {
+	Exporter SpanExporter
+	// Sample decides whether to record a trace started by this service,
+	// all of them if nil. Incoming sampling decisions are honored.
+	Sample func(r Request) bool
+	// ResponseHeaders sets the traceparent of the server span in the
+	// response, for debugging
+	ResponseHeaders bool
+	// OnError is called with the export errors
+	OnError func(error)
+}
+
+// Tracing returns a middleware creating a server span for each request,
+// named after the method and the matched route pattern, as "GET /users/:id".
+// The span continues the trace of the span already in ctx, if any, or of
+// the valid traceparent and tracestate headers. It is stored in the ctx
+// of the handler, see SpanFromContext, StartSpan and InjectTraceContext.
+// A panic is recorded with status 500 before being propagated.
+func Tracing(opts TracingOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r Request) (status int, err error) {
+			rt := r.Route()
+			s := &Span{
+				Name:     r.Method + " " + rt.Host + rt.Pattern,
+				Start:    time.Now(),
+				exporter: opts.Exporter,
+				onError:  opts.OnError,
+			}
+			if parent := SpanFromContext(ctx); parent != nil {
+				s.Parent = parent.Context
+				s.Context = parent.Context
+			} else if parent, err := ParseTraceparent(r.Header.Get("Traceparent")); err == nil {
+				parent.State = strings.Join(r.Header.Values("Tracestate"), ",")
+				s.Parent = parent
+				s.Context = parent
+			} else {
+				s.Context.TraceID = newTraceID()
+				if opts.Sample == nil || opts.Sample(r) {
+					s.Context.Flags = 1
+				}
+			}
+			s.Context.SpanID = newSpanID()
+			s.Attributes = map[string]any{
+				"http.request.method": r.Method,
+				"http.route":          rt.Pattern,
+				"url.path":            r.URL.Path,
+			}
+			if opts.ResponseHeaders {
+				w.Header().Set("Traceparent", s.Context.Traceparent())
+			}
+
+			defer func() {
+				v := recover()
+				if v != nil {
+					status, err = http.StatusInternalServerError, fmt.Errorf("panic: %v", v)
+				}
+				s.mu.Lock()
+				s.Status = status
+				s.Attributes["http.response.status_code"] = status
+				s.mu.Unlock()
+				s.SetError(err)
+				s.Finish()
+				if v != nil {
+					panic(v)
+				}
+			}()
+			return next(context.WithValue(ctx, spanKey{}, s), w, r)
+		}
+	}
+}
+
+// InMemoryExporter keeps the exported spans, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) ExportSpan(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// Spans returns the exported spans, in order of end.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset removes the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONLinesExporter writes each span as a line of JSON, e.g. to a file.
+type JSONLinesExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLinesExporter creates an exporter writing to w.
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	return &JSONLinesExporter{w: w}
+}
+
+func (e *JSONLinesExporter) ExportSpan(s *Span) error {
+	s.mu.Lock()
+	line, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
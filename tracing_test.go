@@ -0,0 +1,153 @@
+package heligo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		sc, err := heligo.ParseTraceparent(test.header)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.header, test.valid, err)
+			continue
+		}
+		if test.valid && sc.Traceparent() != "00"+test.header[2:55] {
+			t.Errorf("%q: unexpected round trip %q", test.header, sc.Traceparent())
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &heligo.InMemoryExporter{}
+	var lines bytes.Buffer
+	router := heligo.New()
+	router.Use(heligo.Tracing(heligo.TracingOptions{Exporter: exporter, ResponseHeaders: true}))
+	router.Use(heligo.Tracing(heligo.TracingOptions{Exporter: heligo.NewJSONLinesExporter(&lines)}))
+	var outgoing http.Header
+	router.Handle("GET", "/users/:id", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		ctx, span := heligo.StartSpan(ctx, "load user")
+		span.SetAttribute("user.id", r.Param("id"))
+		outgoing = http.Header{}
+		heligo.InjectTraceContext(ctx, outgoing)
+		span.Finish()
+		return http.StatusNotFound, errors.New("no such user")
+	})
+
+	r, _ := http.NewRequest("GET", "/users/7", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("Tracestate", "vendor=x")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	server := spans[0]
+	if server.Name != "GET /users/:id" || server.Status != 404 || server.Error != "no such user" {
+		t.Errorf("unexpected server span %+v", server)
+	}
+	if server.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent.SpanID.String() != "00f067aa0ba902b7" ||
+		server.Context.State != "vendor=x" || !server.Context.Sampled() {
+		t.Errorf("the incoming trace context is not continued: %+v", server.Context)
+	}
+	if got := w.Header().Get("Traceparent"); got != server.Context.Traceparent() {
+		t.Errorf("expected the response traceparent %q, got %q", server.Context.Traceparent(), got)
+	}
+
+	// the inner middleware exported the child span and its own server span
+	dec := json.NewDecoder(strings.NewReader(lines.String()))
+	var child, inner map[string]any
+	if err := dec.Decode(&child); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&inner); err != nil {
+		t.Fatal(err)
+	}
+	if child["name"] != "load user" || child["attributes"].(map[string]any)["user.id"] != "7" {
+		t.Errorf("unexpected child span %v", child)
+	}
+	childCtx := child["context"].(map[string]any)
+	if childCtx["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || child["parent"].(map[string]any)["spanId"] != inner["context"].(map[string]any)["spanId"] {
+		t.Errorf("the child span is not a child of the server span: %v", child)
+	}
+	if inner["parent"].(map[string]any)["spanId"] != server.Context.SpanID.String() {
+		t.Errorf("the inner span is not a child of the outer one: %v", inner)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + childCtx["spanId"].(string) + "-01"; outgoing.Get("Traceparent") != want || outgoing.Get("Tracestate") != "vendor=x" {
+		t.Errorf("expected outgoing %q, got %v", want, outgoing)
+	}
+}
+
+func TestTracingSample(t *testing.T) {
+	exporter := &heligo.InMemoryExporter{}
+	router := heligo.New()
+	router.Use(heligo.Tracing(heligo.TracingOptions{
+		Exporter: exporter,
+		Sample:   func(r heligo.Request) bool { return r.URL.Query().Has("trace") },
+	}))
+	router.Handle("GET", "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		return http.StatusOK, nil
+	})
+	for _, test := range []struct {
+		url, traceparent string
+	}{
+		{"/", ""},
+		{"/?trace", ""},
+		{"/", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{"/", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	} {
+		r, _ := http.NewRequest("GET", test.url, nil)
+		if test.traceparent != "" {
+			r.Header.Set("Traceparent", test.traceparent)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if n := len(exporter.Spans()); n != 2 {
+		t.Errorf("expected 2 sampled spans, got %d", n)
+	}
+}
+
+func TestTracingPanic(t *testing.T) {
+	exporter := &heligo.InMemoryExporter{}
+	router := heligo.New()
+	router.Use(heligo.Recover(nil), heligo.Tracing(heligo.TracingOptions{Exporter: exporter}))
+	router.Handle("GET", "/", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		panic("boom")
+	})
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the panic to reach Recover, got %d", w.Code)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Status != 500 || spans[0].Error != "panic: boom" {
+		t.Errorf("expected the span of the panic, got %+v", spans)
+	}
+}
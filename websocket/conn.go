@@ -0,0 +1,459 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// opcodes
+const (
+	opContinuation = 0
+	opText         = 1
+	opBinary       = 2
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// maxControlSize is the maximum payload of control frames
+const maxControlSize = 125
+
+// closeTimeout bounds the wait for the close frame of the peer
+const closeTimeout = 5 * time.Second
+
+var (
+	// ErrClosed is returned when writing after the close frame has been sent
+	ErrClosed = errors.New("websocket: connection closed")
+	// ErrMessageTooLarge is returned when a received message exceeds the limit
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection,
+// or when the connection is closed for a protocol error.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: closed with code " + strconv.Itoa(e.Code)
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// Conn is a WebSocket connection.
+//
+// ReadMessage must be called by one goroutine at a time, while the write
+// methods can be called concurrently. Control frames are handled while
+// reading: pings are answered and a close frame is echoed, so the
+// connection must be read to respond to them.
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	client       bool
+	subprotocol  string
+	maxSize      int64
+	fragmentSize int
+
+	// OnPing and OnPong, if set, are called with the payload of the
+	// received ping and pong frames, before the automatic pong
+	OnPing func(data []byte)
+	OnPong func(data []byte)
+
+	wmu       sync.Mutex
+	closeSent bool
+	reading   bool  // a ReadMessage is running, guarded by wmu
+	readErr   error // guarded by wmu
+	readDone  chan struct{}
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool, subprotocol string, maxSize int64, fragmentSize int) *Conn {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &Conn{
+		conn:         conn,
+		br:           br,
+		client:       client,
+		subprotocol:  subprotocol,
+		maxSize:      maxSize,
+		fragmentSize: fragmentSize,
+		readDone:     make(chan struct{}),
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, empty if none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetReadDeadline sets the deadline of the reads, see net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the writes, see net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage reads the next data message, joining its fragments.
+// It returns a *CloseError when the connection is closed by the peer or
+// for a protocol error, after sending the close frame, and
+// ErrMessageTooLarge when the message exceeds the limit. After an error
+// the following calls return the same error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.wmu.Lock()
+	if err := c.readErr; err != nil {
+		c.wmu.Unlock()
+		return 0, nil, err
+	}
+	c.reading = true
+	c.wmu.Unlock()
+	typ, msg, err := c.readMessage()
+	c.wmu.Lock()
+	c.reading = false
+	if err != nil {
+		c.readErr = err
+		close(c.readDone)
+	}
+	c.wmu.Unlock()
+	return typ, msg, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame(c.maxSize - int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			if c.OnPing != nil {
+				c.OnPing(payload)
+			}
+			if err := c.writeFrame(opPong, payload, true); err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.OnPong != nil {
+				c.OnPong(payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.receiveClose(payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "data frame inside a fragmented message")
+			}
+			typ = MessageType(op)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+		msg = append(msg, payload...)
+		if fin {
+			break
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 text")
+	}
+	if msg == nil {
+		msg = []byte{}
+	}
+	return typ, msg, nil
+}
+
+// readFrame reads a frame, with data payloads limited to max bytes
+func (c *Conn) readFrame(max int64) (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	op = h[0] & 0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	masked := h[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid frame masking")
+	}
+	control := op >= opClose
+	switch {
+	case op > opBinary && op < opClose || op > opPong:
+		return false, 0, nil, c.fail(CloseProtocolError, "unknown opcode "+strconv.Itoa(int(op)))
+	case control && !fin:
+		return false, 0, nil, c.fail(CloseProtocolError, "fragmented control frame")
+	}
+
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(b[:]))
+		if n < 0 {
+			return false, 0, nil, c.fail(CloseProtocolError, "invalid frame length")
+		}
+	}
+	if control && n > maxControlSize {
+		return false, 0, nil, c.fail(CloseProtocolError, "control frame too large")
+	}
+	if !control && n > max {
+		c.fail(CloseMessageTooBig, "")
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		mask(key, payload)
+	}
+	return fin, op, payload, nil
+}
+
+// receiveClose handles a close frame, echoing it
+func (c *Conn) receiveClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return c.fail(CloseInvalidPayload, "invalid UTF-8 close reason")
+		}
+	}
+	var echo []byte
+	if ce.Code != CloseNoStatus {
+		echo = closePayload(ce.Code, "")
+	}
+	c.writeFrame(opClose, echo, true)
+	c.conn.Close()
+	return ce
+}
+
+// fail sends a close frame for a protocol error and closes the connection
+func (c *Conn) fail(code int, reason string) error {
+	c.writeFrame(opClose, closePayload(code, reason), true)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+}
+
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlSize-2 {
+		reason = reason[:maxControlSize-2]
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(b, reason...)
+}
+
+// WriteMessage sends a data message, in fragments if FragmentSize is set.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return errors.New("websocket: invalid message type")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	op := byte(typ)
+	for {
+		chunk, fin := data, true
+		if c.fragmentSize > 0 && len(data) > c.fragmentSize {
+			chunk, fin = data[:c.fragmentSize], false
+		}
+		if err := c.writeFrameLocked(op, chunk, fin); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data = data[len(chunk):]
+		op = opContinuation
+	}
+}
+
+// Ping sends a ping with data, at most 125 bytes.
+// The pong is passed to OnPong by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeFrame(opPing, data, true)
+}
+
+// Close performs the close handshake, sending a close frame with code
+// and reason, waiting for the close frame of the peer and closing the
+// connection. If a ReadMessage is running, it receives the peer frame,
+// otherwise Close reads and discards the messages until it.
+// A zero code sends a close frame without code and reason. The codes that
+// must not be sent, as CloseNoStatus and CloseAbnormal, are rejected.
+func (c *Conn) Close(code int, reason string) error {
+	var payload []byte
+	if code != 0 {
+		if !validCloseCode(code) {
+			return errors.New("websocket: invalid close code")
+		}
+		payload = closePayload(code, reason)
+	}
+	c.wmu.Lock()
+	if c.closeSent {
+		c.wmu.Unlock()
+		return nil
+	}
+	err := c.writeFrameLocked(opClose, payload, true)
+	reading, readErr := c.reading, c.readErr
+	c.wmu.Unlock()
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	switch {
+	case readErr != nil:
+	case reading:
+		select {
+		case <-c.readDone:
+		case <-time.After(closeTimeout):
+		}
+	default:
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	c.conn.Close()
+	return nil
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte, fin bool) error {
+	if len(payload) > maxControlSize {
+		return errors.New("websocket: control frame too large")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrameLocked(op, payload, fin)
+}
+
+// writeFrameLocked writes a frame, marking the close frame as sent
+func (c *Conn) writeFrameLocked(op byte, payload []byte, fin bool) error {
+	if op == opClose {
+		c.closeSent = true
+	}
+	b := make([]byte, 0, 14+len(payload))
+	first := op
+	if fin {
+		first |= 0x80
+	}
+	b = append(b, first)
+	var m byte
+	if c.client {
+		m = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, m|byte(n))
+	case n <= 0xffff:
+		b = append(b, m|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, m|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if c.client {
+		var key [4]byte
+		rand.Read(key[:])
+		b = append(b, key[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		mask(key, b[start:])
+	} else {
+		b = append(b, payload...)
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// mask applies the masking key to b
+func mask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
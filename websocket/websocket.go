@@ -0,0 +1,270 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) with the
+// standard library: the server handshake for Heligo handlers, a client for
+// tests and tools, and connections exchanging text and binary messages.
+// Handshake failures are returned to the router ErrorHandler:
+//
+//	router.Handle("GET", "/ws", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+//		conn, err := websocket.Upgrade(w, r, websocket.Options{Subprotocols: []string{"chat.v1"}})
+//		if err != nil {
+//			return websocket.HandshakeStatus(err), err
+//		}
+//		defer conn.Close(websocket.CloseNormal, "")
+//		for {
+//			typ, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return http.StatusSwitchingProtocols, nil
+//			}
+//			conn.WriteMessage(typ, msg)
+//		}
+//	})
+//
+// Compression extensions are not supported.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sted/heligo"
+)
+
+// guid is appended to the key to compute the accept header
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the default limit of the received messages
+const DefaultMaxMessageSize = 1 << 20
+
+// Options configures Upgrade.
+type Options struct {
+	// Subprotocols are the supported subprotocols, in order of preference
+	Subprotocols []string
+	// CheckOrigin accepts or rejects the Origin of the request.
+	// By default requests with an Origin header are accepted only if its
+	// host is the request host.
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageSize limits the size of the received messages,
+	// DefaultMaxMessageSize if zero
+	MaxMessageSize int64
+	// FragmentSize, if positive, splits the sent messages in frames of
+	// at most this size
+	FragmentSize int
+}
+
+// HandshakeError is returned by Upgrade when the request is not a valid
+// WebSocket handshake. The response is not written: the handler returns
+// Status and the error, for the router ErrorHandler.
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// HandshakeStatus returns the status of a HandshakeError,
+// or 500 for other errors.
+func HandshakeStatus(err error) int {
+	if he, ok := errors.AsType[*HandshakeError](err); ok {
+		return he.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// Upgrade performs the server handshake, taking over the connection.
+// On failure only the headers of the error response are set: see HandshakeError.
+func Upgrade(w http.ResponseWriter, r heligo.Request, opts Options) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		return nil, &HandshakeError{http.StatusMethodNotAllowed, "the handshake method must be GET"}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "not a websocket handshake"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "unsupported version"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		return nil, &HandshakeError{http.StatusBadRequest, "invalid Sec-WebSocket-Key"}
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r.Request) {
+		return nil, &HandshakeError{http.StatusForbidden, "origin not allowed"}
+	}
+	subprotocol := ""
+	offered := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, p := range opts.Subprotocols {
+		if slices.Contains(offered, p) {
+			subprotocol = p
+			break
+		}
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, &HandshakeError{http.StatusInternalServerError, "can't hijack the connection: " + err.Error()}
+	}
+	// clear the deadlines set by the server
+	netConn.SetDeadline(time.Time{})
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	brw.Writer.WriteString(resp + "\r\n")
+	if err := brw.Writer.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return newConn(netConn, brw.Reader, false, subprotocol, opts.MaxMessageSize, opts.FragmentSize), nil
+}
+
+// sameOrigin accepts requests without Origin or with the host of the request
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + guid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerTokens returns the comma separated tokens of the header values
+func headerTokens(h http.Header, name string) []string {
+	var tokens []string
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContains(h http.Header, name string, token string) bool {
+	return slices.ContainsFunc(headerTokens(h, name), func(t string) bool {
+		return strings.EqualFold(t, token)
+	})
+}
+
+// DialOptions configures Dial.
+type DialOptions struct {
+	// Subprotocols are offered to the server
+	Subprotocols []string
+	// Header is added to the handshake request, e.g. with an Origin
+	Header http.Header
+	// MaxMessageSize and FragmentSize are as in Options
+	MaxMessageSize int64
+	FragmentSize   int
+	// TLSConfig is used for wss URLs
+	TLSConfig *tls.Config
+}
+
+// Dial opens a client connection to a ws, wss, http or https URL.
+// On a failed handshake the response is returned with the error.
+func Dial(ctx context.Context, rawURL string, opts DialOptions) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	var netConn net.Conn
+	if secure {
+		cfg := opts.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		netConn, err = (&tls.Dialer{Config: cfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		netConn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	var k [16]byte
+	rand.Read(k[:])
+	key := base64.StdEncoding.EncodeToString(k[:])
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}, Host: u.Host}
+	for name, values := range opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(opts.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake, status %d", resp.StatusCode)
+	}
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(opts.Subprotocols, subprotocol) {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: unexpected subprotocol %q", subprotocol)
+	}
+	netConn.SetDeadline(time.Time{})
+	return newConn(netConn, br, true, subprotocol, opts.MaxMessageSize, opts.FragmentSize), resp, nil
+}
@@ -0,0 +1,265 @@
+package websocket_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sted/heligo"
+	"github.com/sted/heligo/websocket"
+)
+
+// newServer serves an echo endpoint on /ws, sending the read errors to errs
+func newServer(t *testing.T, opts websocket.Options, errs chan<- error) *httptest.Server {
+	t.Helper()
+	router := heligo.New()
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		http.Error(w, http.StatusText(status), status)
+	}
+	router.Handle("GET", "/ws", func(ctx context.Context, w http.ResponseWriter, r heligo.Request) (int, error) {
+		conn, err := websocket.Upgrade(w, r, opts)
+		if err != nil {
+			return websocket.HandshakeStatus(err), err
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return http.StatusSwitchingProtocols, nil
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				errs <- err
+				return http.StatusSwitchingProtocols, nil
+			}
+		}
+	})
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEcho(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := newServer(t, websocket.Options{Subprotocols: []string{"chat.v2", "chat.v1"}}, errs)
+
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"/ws", websocket.DialOptions{
+		Subprotocols: []string{"chat.v1", "chat.v2"},
+		FragmentSize: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := conn.Subprotocol(); p != "chat.v2" {
+		t.Errorf("expected the server preference chat.v2, got %q", p)
+	}
+
+	messages := []struct {
+		typ  websocket.MessageType
+		data []byte
+	}{
+		{websocket.TextMessage, []byte("hello")},
+		{websocket.TextMessage, []byte{}},
+		{websocket.BinaryMessage, []byte{0, 1, 2, 255}},
+		// fragmented by the client, 16 bit length from the server
+		{websocket.TextMessage, []byte(strings.Repeat("é", 500))},
+		// 64 bit length from the server
+		{websocket.BinaryMessage, bytes.Repeat([]byte{7}, 70000)},
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(m.typ, m.data); err != nil {
+			t.Fatal(err)
+		}
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != m.typ || !bytes.Equal(data, m.data) {
+			t.Errorf("echo of %d bytes: got type %d and %d bytes", len(m.data), typ, len(data))
+		}
+	}
+
+	var pong []byte
+	conn.OnPong = func(data []byte) { pong = data }
+	if err := conn.Ping([]byte("are you there")); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("after ping"))
+	if _, data, _ := conn.ReadMessage(); string(data) != "after ping" {
+		t.Errorf("unexpected message %q", data)
+	}
+	if string(pong) != "are you there" {
+		t.Errorf("unexpected pong %q", pong)
+	}
+
+	if err := conn.Close(websocket.CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	var ce *websocket.CloseError
+	if err := <-errs; !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway || ce.Reason != "bye" {
+		t.Errorf("expected the close by the client, got %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("late")); err != websocket.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCloseCodes(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := newServer(t, websocket.Options{}, errs)
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"/ws", websocket.DialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the codes reserved for the reports of the endpoints
+	for _, code := range []int{websocket.CloseNoStatus, websocket.CloseAbnormal, 1015} {
+		if err := conn.Close(code, ""); err == nil {
+			t.Errorf("expected an error for code %d", code)
+		}
+	}
+	// a zero code sends an empty close frame, reported as 1005
+	if err := conn.Close(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	var ce *websocket.CloseError
+	if err := <-errs; !errors.As(err, &ce) || ce.Code != websocket.CloseNoStatus {
+		t.Errorf("expected close code 1005, got %v", err)
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := newServer(t, websocket.Options{MaxMessageSize: 10}, errs)
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"/ws", websocket.DialOptions{FragmentSize: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+
+	// the limit applies to the whole message, not to the fragments
+	conn.WriteMessage(websocket.TextMessage, []byte("0123456789ab"))
+	if err := <-errs; err != websocket.ErrMessageTooLarge {
+		t.Errorf("expected ErrMessageTooLarge, got %v", err)
+	}
+	var ce *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &ce) || ce.Code != websocket.CloseMessageTooBig {
+		t.Errorf("expected close code 1009, got %v", err)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", []byte{0x81, 0x01, 'a'}, websocket.CloseProtocolError},
+		{"reserved bits", []byte{0xc1, 0x81, 0, 0, 0, 0, 'a'}, websocket.CloseProtocolError},
+		{"unknown opcode", []byte{0x83, 0x80, 0, 0, 0, 0}, websocket.CloseProtocolError},
+		{"fragmented ping", []byte{0x09, 0x80, 0, 0, 0, 0}, websocket.CloseProtocolError},
+		{"continuation", []byte{0x80, 0x81, 0, 0, 0, 0, 'a'}, websocket.CloseProtocolError},
+		{"invalid utf-8", []byte{0x81, 0x81, 0, 0, 0, 0, 0xff}, websocket.CloseInvalidPayload},
+		{"invalid close code", []byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xed}, websocket.CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			srv := newServer(t, websocket.Options{}, errs)
+			conn, _, err := websocket.Dial(context.Background(), srv.URL+"/ws", websocket.DialOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.NetConn().Close()
+			conn.NetConn().Write(tt.frame)
+			var ce *websocket.CloseError
+			if err := <-errs; !errors.As(err, &ce) || ce.Code != tt.code {
+				t.Errorf("expected close code %d, got %v", tt.code, err)
+			}
+			if _, _, err := conn.ReadMessage(); !errors.As(err, &ce) || ce.Code != tt.code {
+				t.Errorf("expected close frame with code %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	srv := newServer(t, websocket.Options{}, make(chan error, 1))
+	url := srv.URL + "/ws"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("plain GET: unexpected status %d", resp.StatusCode)
+	}
+	// written once, by the ErrorHandler
+	if string(body) != "Upgrade Required\n" {
+		t.Errorf("plain GET: unexpected body %q", body)
+	}
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("version 8: unexpected status %d", resp.StatusCode)
+	}
+
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "short")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid key: unexpected status %d", resp.StatusCode)
+	}
+
+	_, resp, err = websocket.Dial(context.Background(), url, websocket.DialOptions{
+		Header: http.Header{"Origin": {"https://evil.example"}},
+	})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross origin: expected 403, got %v", err)
+	}
+
+	conn, _, err := websocket.Dial(context.Background(), url, websocket.DialOptions{
+		Header: http.Header{"Origin": {srv.URL}},
+	})
+	if err != nil {
+		t.Fatalf("same origin: %v", err)
+	}
+	if p := conn.Subprotocol(); p != "" {
+		t.Errorf("unexpected subprotocol %q", p)
+	}
+	conn.Close(websocket.CloseNormal, "")
+}
+
+func TestCheckOrigin(t *testing.T) {
+	srv := newServer(t, websocket.Options{
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://app.example"
+		},
+	}, make(chan error, 1))
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"/ws", websocket.DialOptions{
+		Header: http.Header{"Origin": {"https://app.example"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(websocket.CloseNormal, "")
+}